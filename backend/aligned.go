@@ -0,0 +1,153 @@
+package backend
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/goswap/stats-api/models"
+)
+
+// Period is a calendar unit that aligned windows start on, unlike a time_frame duration these don't
+// drift with the request time and months can be any length
+type Period string
+
+const (
+	PeriodHour  Period = "hour"
+	PeriodDay   Period = "day"
+	PeriodWeek  Period = "week"
+	PeriodMonth Period = "month"
+)
+
+// ParsePeriod returns the period named s, or false if it isn't one
+func ParsePeriod(s string) (Period, bool) {
+	switch p := Period(s); p {
+	case PeriodHour, PeriodDay, PeriodWeek, PeriodMonth:
+		return p, true
+	}
+	return "", false
+}
+
+// Start returns the start of the period that t is in, in loc. Weeks start on Monday. Hours are whole
+// hours of the day everywhere since that's what's stored.
+func (p Period) Start(t time.Time, loc *time.Location) time.Time {
+	t = t.In(loc)
+	y, m, d := t.Date()
+	switch p {
+	case PeriodDay:
+		return time.Date(y, m, d, 0, 0, 0, 0, loc)
+	case PeriodWeek:
+		return time.Date(y, m, d-(int(t.Weekday())+6)%7, 0, 0, 0, 0, loc)
+	case PeriodMonth:
+		return time.Date(y, m, 1, 0, 0, 0, 0, loc)
+	}
+	return t.Truncate(time.Hour)
+}
+
+// Next returns the start of the period after the one starting at start, days can be 23 or 25 hours
+// long around daylight saving changes
+func (p Period) Next(start time.Time) time.Time {
+	switch p {
+	case PeriodDay:
+		return start.AddDate(0, 0, 1)
+	case PeriodWeek:
+		return start.AddDate(0, 0, 7)
+	case PeriodMonth:
+		return start.AddDate(0, 1, 0)
+	}
+	return start.Add(time.Hour)
+}
+
+// windows are the aligned windows between two times
+type windows struct {
+	starts []time.Time
+	end    time.Time
+
+	// the coarsest stored resolution that every window boundary falls on
+	resolution time.Duration
+}
+
+func newWindows(from, to time.Time, p Period, loc *time.Location) *windows {
+	w := new(windows)
+	for s := p.Start(from, loc); s.Before(to); s = p.Next(s) {
+		w.starts = append(w.starts, s)
+		w.end = p.Next(s)
+	}
+	w.resolution = Resolutions[0]
+	for i := len(Resolutions) - 1; i > 0; i-- {
+		if w.alignedTo(Resolutions[i]) {
+			w.resolution = Resolutions[i]
+			break
+		}
+	}
+	return w
+}
+
+func (w *windows) alignedTo(resolution time.Duration) bool {
+	for _, s := range append(w.starts, w.end) {
+		if !RollupStart(s, resolution).Equal(s) {
+			return false
+		}
+	}
+	return true
+}
+
+// start returns the start of the window that a bucket at t goes in. Buckets that start before a
+// boundary that isn't on the hour (eg half hour time zones) go in the window before it.
+func (w *windows) start(t time.Time) time.Time {
+	i := sort.Search(len(w.starts), func(i int) bool { return w.starts[i].After(t) })
+	if i == 0 {
+		return w.starts[0]
+	}
+	return w.starts[i-1]
+}
+
+// bounds returns the exclusive range to read at w.resolution so each stored bucket comes back as is
+func (w *windows) bounds() (time.Time, time.Time) {
+	return w.starts[0].Add(-time.Second), w.end.Add(-time.Second)
+}
+
+// GetTotalsAligned returns the totals in windows aligned to the start of each period in loc, between
+// from and to. Each window is rolled up from the coarsest stored buckets that fit in it.
+func GetTotalsAligned(ctx context.Context, db StatsBackend, from, to time.Time, p Period, loc *time.Location) ([]*models.TotalBucket, error) {
+	w := newWindows(from, to, p, loc)
+	if len(w.starts) == 0 {
+		return []*models.TotalBucket{}, nil
+	}
+	f, t := w.bounds()
+	totals, err := db.GetTotals(ctx, f, t, w.resolution)
+	if err != nil {
+		return nil, err
+	}
+	return MergeTotals(totals, w.start), nil
+}
+
+// GetPairBucketsAligned returns the pair buckets in windows aligned to the start of each period in
+// loc, like GetTotalsAligned
+func GetPairBucketsAligned(ctx context.Context, db StatsBackend, pair string, from, to time.Time, p Period, loc *time.Location) ([]*models.PairBucket, error) {
+	w := newWindows(from, to, p, loc)
+	if len(w.starts) == 0 {
+		return []*models.PairBucket{}, nil
+	}
+	f, t := w.bounds()
+	pbs, err := db.GetPairBuckets(ctx, pair, f, t, w.resolution)
+	if err != nil {
+		return nil, err
+	}
+	return MergePairBuckets(pbs, w.start), nil
+}
+
+// GetTokenBucketsAligned returns the token buckets in windows aligned to the start of each period in
+// loc, like GetTotalsAligned
+func GetTokenBucketsAligned(ctx context.Context, db StatsBackend, token string, from, to time.Time, p Period, loc *time.Location) ([]*models.TokenBucket, error) {
+	w := newWindows(from, to, p, loc)
+	if len(w.starts) == 0 {
+		return []*models.TokenBucket{}, nil
+	}
+	f, t := w.bounds()
+	tbs, err := db.GetTokenBuckets(ctx, token, f, t, w.resolution)
+	if err != nil {
+		return nil, err
+	}
+	return MergeTokenBuckets(tbs, w.start), nil
+}
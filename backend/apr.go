@@ -0,0 +1,54 @@
+package backend
+
+import (
+	"context"
+	"time"
+
+	"github.com/goswap/stats-api/models"
+	"github.com/shopspring/decimal"
+)
+
+// Year is what APRs are annualized over
+const Year = 365 * Day
+
+// AddFeeAPRs sets FeeAPR on each of the buckets, each pair's totals between from and to, to the pair's
+// fees annualized over its average liquidityUSD in that window. Both are read from the pair's hourly
+// buckets, or daily ones for windows longer than a week, so they always come from the same resolution.
+// Pairs without liquidity are left at 0.
+func AddFeeAPRs(ctx context.Context, db StatsBackend, pbs []*models.PairBucket, from, to time.Time) error {
+	w := to.Sub(from)
+	if w <= 0 {
+		return nil
+	}
+	interval := time.Hour
+	if w > Week {
+		interval = Day
+	}
+	samples, err := db.GetPairBuckets(ctx, "", from, to, interval)
+	if err != nil {
+		return err
+	}
+	fees := map[string]decimal.Decimal{}
+	sums := map[string]decimal.Decimal{}
+	counts := map[string]int64{}
+	for _, s := range samples {
+		fees[s.Address] = fees[s.Address].Add(s.FeesUSD)
+		sums[s.Address] = sums[s.Address].Add(s.LiquidityUSD)
+		counts[s.Address]++
+	}
+
+	yearSecs := decimal.NewFromInt(int64(Year / time.Second))
+	windowSecs := decimal.NewFromInt(int64(w / time.Second))
+	for _, pb := range pbs {
+		n := counts[pb.Address]
+		if n == 0 {
+			continue
+		}
+		avg := sums[pb.Address].Div(decimal.NewFromInt(n))
+		if !avg.IsPositive() {
+			continue
+		}
+		pb.FeeAPR = fees[pb.Address].Mul(yearSecs).DivRound(avg.Mul(windowSecs), 18)
+	}
+	return nil
+}
@@ -98,16 +98,19 @@ func TestTotalBuckets(t *testing.T) {
 		{
 			Time:         start,
 			VolumeUSD:    one,
+			FeesUSD:      one,
 			LiquidityUSD: one,
 		},
 		{
 			Time:         start.Add(1 * time.Hour),
 			VolumeUSD:    one,
+			FeesUSD:      one,
 			LiquidityUSD: two,
 		},
 		{
 			Time:         start.Add(2 * time.Hour),
 			VolumeUSD:    four,
+			FeesUSD:      four,
 			LiquidityUSD: two,
 		},
 	}
@@ -116,6 +119,7 @@ func TestTotalBuckets(t *testing.T) {
 		{
 			Time:         start,
 			VolumeUSD:    two.Add(four),
+			FeesUSD:      two.Add(four),
 			LiquidityUSD: two,
 		},
 	}
@@ -150,3 +154,254 @@ func TestTotalBuckets(t *testing.T) {
 		}
 	}
 }
+
+func TestTotalRollups(t *testing.T) {
+	ctx := context.Background()
+	day := time.Date(2021, 3, 10, 0, 0, 0, 0, time.UTC)
+	db := NewMock([]*models.TotalBucket{
+		{Time: day.Add(-time.Hour), VolumeUSD: decimal.NewFromInt(1)},
+		{Time: day, VolumeUSD: decimal.NewFromInt(2)},
+		{Time: day.Add(time.Hour), VolumeUSD: decimal.NewFromInt(4)},
+	})
+	err := db.ReplaceRollups(ctx, Day, day, nil, nil, []*models.TotalBucket{{Time: day, VolumeUSD: decimal.NewFromInt(24)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		from, to time.Time
+
+		exp decimal.Decimal
+	}{
+		// a whole day reads the day rollup
+		{day.Add(-time.Second), day.Add(Day - time.Second), decimal.NewFromInt(24)},
+		// 24h from partway through the day adds up the hours after it instead
+		{day.Add(30 * time.Minute), day.Add(Day + 30*time.Minute), decimal.NewFromInt(4)},
+	}
+	for i, test := range tests {
+		totals, err := db.GetTotals(ctx, test.from, test.to, Day)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(totals) != 1 || !totals[0].VolumeUSD.Equal(test.exp) {
+			t.Errorf("test %v | expected one total of %v, got %v", i, test.exp, totals)
+		}
+	}
+}
+
+func TestMockWriter(t *testing.T) {
+	start := time.Now().Truncate(time.Hour)
+
+	one := decimal.NewFromFloat(1)
+	two := decimal.NewFromFloat(2)
+
+	ctx := context.Background()
+	db := NewMock()
+
+	// saving the same address and time again overwrites
+	for _, b := range []*models.PairBucket{
+		{Address: "0x0", Time: start, VolumeUSD: one},
+		{Address: "0x0", Time: start.Add(1 * time.Hour), VolumeUSD: one},
+		{Address: "0x0", Time: start, VolumeUSD: two},
+	} {
+		err := db.SavePairBucket(ctx, b)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	pbs, err := db.GetPairBuckets(ctx, "0x0", start, start.Add(2*time.Hour), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pbs) != 2 || !pbs[0].VolumeUSD.Equal(two) || !pbs[1].VolumeUSD.Equal(one) {
+		t.Errorf("results mismatch after save: %v", pbs)
+	}
+
+	err = db.DeleteBuckets(ctx, start.Add(1*time.Hour), time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pbs, err = db.GetPairBuckets(ctx, "0x0", start, start.Add(2*time.Hour), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pbs) != 1 || !pbs[0].Time.Equal(start) {
+		t.Errorf("results mismatch after delete: %v", pbs)
+	}
+
+	err = db.SavePairCheck(ctx, &models.PairCheck{Address: "0x0", LastBlockNumber: 42})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pcs, err := db.GetPairChecks(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pcs["0x0"] == nil || pcs["0x0"].LastBlockNumber != 42 {
+		t.Errorf("pair check mismatch: %v", pcs)
+	}
+}
+
+func TestPeriods(t *testing.T) {
+	ny, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a Wednesday, the day after daylight saving starts in New York
+	at := time.Date(2021, 3, 15, 3, 30, 0, 0, time.UTC)
+
+	tests := []struct {
+		p   Period
+		loc *time.Location
+
+		start, next time.Time
+	}{
+		{PeriodHour, time.UTC, time.Date(2021, 3, 15, 3, 0, 0, 0, time.UTC), time.Date(2021, 3, 15, 4, 0, 0, 0, time.UTC)},
+		{PeriodDay, time.UTC, time.Date(2021, 3, 15, 0, 0, 0, 0, time.UTC), time.Date(2021, 3, 16, 0, 0, 0, 0, time.UTC)},
+		{PeriodWeek, time.UTC, time.Date(2021, 3, 15, 0, 0, 0, 0, time.UTC), time.Date(2021, 3, 22, 0, 0, 0, 0, time.UTC)},
+		{PeriodMonth, time.UTC, time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC), time.Date(2021, 4, 1, 0, 0, 0, 0, time.UTC)},
+		// still the 14th in New York, which is only 23 hours long
+		{PeriodDay, ny, time.Date(2021, 3, 14, 5, 0, 0, 0, time.UTC), time.Date(2021, 3, 15, 4, 0, 0, 0, time.UTC)},
+		{PeriodWeek, ny, time.Date(2021, 3, 8, 5, 0, 0, 0, time.UTC), time.Date(2021, 3, 15, 4, 0, 0, 0, time.UTC)},
+	}
+	for i, test := range tests {
+		start := test.p.Start(at, test.loc)
+		if !start.Equal(test.start) {
+			t.Errorf("test %v | expected %v to start at %v, got %v", i, test.p, test.start, start)
+		}
+		if next := test.p.Next(start); !next.Equal(test.next) {
+			t.Errorf("test %v | expected the next %v at %v, got %v", i, test.p, test.next, next)
+		}
+	}
+	if _, ok := ParsePeriod("fortnight"); ok {
+		t.Errorf("expected fortnight not to be a period")
+	}
+}
+
+func TestAligned(t *testing.T) {
+	ctx := context.Background()
+	ny, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// an hourly total for the 13th and 14th in New York, the 14th only has 23 hours
+	from := time.Date(2021, 3, 13, 5, 0, 0, 0, time.UTC)
+	to := time.Date(2021, 3, 15, 4, 0, 0, 0, time.UTC)
+	var seed []*models.TotalBucket
+	for h := from; h.Before(to); h = h.Add(time.Hour) {
+		seed = append(seed, &models.TotalBucket{Time: h, VolumeUSD: decimal.NewFromInt(1), LiquidityUSD: decimal.NewFromInt(h.Unix())})
+	}
+	db := NewMock(seed)
+
+	// the request time doesn't matter, only which days it covers
+	totals, err := GetTotalsAligned(ctx, db, from.Add(90*time.Minute), to.Add(-time.Minute), PeriodDay, ny)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(totals) != 2 {
+		t.Fatalf("expected 2 days, got %+v", totals)
+	}
+	for i, exp := range []struct {
+		start  time.Time
+		volume int64
+	}{
+		{time.Date(2021, 3, 13, 0, 0, 0, 0, ny), 24},
+		{time.Date(2021, 3, 14, 0, 0, 0, 0, ny), 23},
+	} {
+		if !totals[i].Time.Equal(exp.start) || !totals[i].VolumeUSD.Equal(decimal.NewFromInt(exp.volume)) {
+			t.Errorf("day %v | expected %v volume starting %v, got %v starting %v", i, exp.volume, exp.start, totals[i].VolumeUSD, totals[i].Time)
+		}
+	}
+	if !totals[1].LiquidityUSD.Equal(decimal.NewFromInt(to.Add(-time.Hour).Unix())) {
+		t.Errorf("expected the liquidity of the last hour, got %v", totals[1].LiquidityUSD)
+	}
+
+	// months in UTC are summed from the daily rollups
+	for d := 0; d < 45; d++ {
+		day := time.Date(2021, 3, 1+d, 0, 0, 0, 0, time.UTC)
+		err = db.ReplaceRollups(ctx, Day, day, []*models.PairBucket{{Address: "0x0", Time: day, VolumeUSD: decimal.NewFromInt(1), Reserve0: decimal.NewFromInt(int64(d))}}, nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	pbs, err := GetPairBucketsAligned(ctx, db, "0x0", time.Date(2021, 3, 10, 0, 0, 0, 0, time.UTC), time.Date(2021, 4, 10, 0, 0, 0, 0, time.UTC), PeriodMonth, time.UTC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pbs) != 2 {
+		t.Fatalf("expected 2 months, got %+v", pbs)
+	}
+	if !pbs[0].VolumeUSD.Equal(decimal.NewFromInt(31)) || !pbs[0].Reserve0.Equal(decimal.NewFromInt(30)) || !pbs[0].Time.Equal(time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("expected all of March, got %v volume and %v reserve at %v", pbs[0].VolumeUSD, pbs[0].Reserve0, pbs[0].Time)
+	}
+	if !pbs[1].VolumeUSD.Equal(decimal.NewFromInt(14)) || !pbs[1].Reserve0.Equal(decimal.NewFromInt(44)) {
+		t.Errorf("expected the stored days of April, got %v volume and %v reserve", pbs[1].VolumeUSD, pbs[1].Reserve0)
+	}
+}
+
+func TestFill(t *testing.T) {
+	ctx := context.Background()
+	start := time.Date(2021, 5, 1, 0, 0, 0, 0, time.UTC)
+	one := decimal.NewFromInt(1)
+	two := decimal.NewFromInt(2)
+	db := NewMock(
+		[]*models.PairBucket{
+			{Address: "0x0", Pair: "A-B", Time: start, VolumeUSD: one, Reserve0: one, Price0USD: one},
+			{Address: "0x0", Pair: "A-B", Time: start.Add(3 * time.Hour), VolumeUSD: two, Reserve0: two, Price0USD: two},
+		},
+		[]*models.TotalBucket{
+			{Time: start.Add(time.Hour), VolumeUSD: one, LiquidityUSD: two},
+		},
+	)
+
+	iv, err := NewIntervals(start, start.Add(5*time.Hour), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pbs, err := db.GetPairBuckets(ctx, "0x0", start, start.Add(5*time.Hour), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pbs = FillPairBuckets(pbs, iv, FillPrevious, "0x0")
+	if len(pbs) != 5 {
+		t.Fatalf("expected a bucket for each of 5 hours, got %v", len(pbs))
+	}
+	for i, exp := range []struct {
+		volume, reserve0 decimal.Decimal
+	}{
+		{one, one}, {decimal.Zero, one}, {decimal.Zero, one}, {two, two}, {decimal.Zero, two},
+	} {
+		pb := pbs[i]
+		if !pb.Time.Equal(start.Add(time.Duration(i)*time.Hour)) || pb.Pair != "A-B" {
+			t.Errorf("hour %v | expected A-B at %v, got %v at %v", i, start.Add(time.Duration(i)*time.Hour), pb.Pair, pb.Time)
+		}
+		if !pb.VolumeUSD.Equal(exp.volume) || !pb.Reserve0.Equal(exp.reserve0) || !pb.Price0USD.Equal(exp.reserve0) {
+			t.Errorf("hour %v | expected volume %v and reserve %v, got %v and %v", i, exp.volume, exp.reserve0, pb.VolumeUSD, pb.Reserve0)
+		}
+	}
+
+	totals, err := db.GetTotals(ctx, start, start.Add(5*time.Hour), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	totals = FillTotals(totals, iv, FillZero)
+	if len(totals) != 5 || !totals[1].VolumeUSD.Equal(one) || !totals[2].LiquidityUSD.IsZero() || !totals[0].Time.Equal(start) {
+		t.Errorf("expected 5 totals with zeros around the second, got %+v", totals)
+	}
+
+	// a token with no buckets at all still gets a series
+	iv, err = NewAlignedIntervals(start, start.AddDate(0, 3, 0), PeriodMonth, time.UTC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tbs := FillTokenBuckets(nil, iv, FillPrevious, "0x1")
+	if len(tbs) != 3 || tbs[0].Address != "0x1" || !tbs[2].Time.Equal(time.Date(2021, 7, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("expected 3 empty months, got %+v", tbs)
+	}
+
+	_, err = NewIntervals(start, start.AddDate(2, 0, 0), time.Hour)
+	if err != ErrTooManyIntervals {
+		t.Errorf("expected too many intervals for 2 years of hours, got %v", err)
+	}
+}
@@ -179,3 +179,13 @@ func (c *cache) GetTokenBuckets(ctx context.Context, token string, from, to time
 	tokens, _ := v.([]*models.TokenBucket)
 	return tokens, err
 }
+
+// GetSwaps isn't cached, recent trades should show up as soon as they're collected
+func (c *cache) GetSwaps(ctx context.Context, f *SwapFilter) ([]*models.Swap, *EventCursor, error) {
+	return c.db.GetSwaps(ctx, f)
+}
+
+// GetLiquidityEvents isn't cached either, like GetSwaps
+func (c *cache) GetLiquidityEvents(ctx context.Context, f *LiquidityEventFilter) ([]*models.LiquidityEvent, *EventCursor, error) {
+	return c.db.GetLiquidityEvents(ctx, f)
+}
@@ -0,0 +1,160 @@
+package backend
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/goswap/stats-api/models"
+	"github.com/shopspring/decimal"
+)
+
+const (
+	// CollectionSwaps holds every swap, keyed by tx hash and log index
+	CollectionSwaps = "swaps"
+	// CollectionLiquidityEvents holds every mint and burn, keyed by tx hash and log index
+	CollectionLiquidityEvents = "liquidity_events"
+)
+
+// ErrInvalidCursor is returned when an event cursor can't be parsed
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// EventCursor is where a page of swaps or liquidity events ended, they're returned newest first
+type EventCursor struct {
+	Time        time.Time
+	BlockNumber int64
+	LogIndex    int64
+}
+
+// NewEventCursor returns the cursor for the page after the event at blockNumber and logIndex
+func NewEventCursor(t time.Time, blockNumber int64, logIndex int64) *EventCursor {
+	return &EventCursor{Time: t, BlockNumber: blockNumber, LogIndex: logIndex}
+}
+
+// ParseEventCursor parses a cursor from String
+func ParseEventCursor(s string) (*EventCursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	var t int64
+	c := new(EventCursor)
+	_, err = fmt.Sscanf(string(b), "%d:%d:%d", &t, &c.BlockNumber, &c.LogIndex)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	c.Time = time.Unix(t, 0)
+	return c, nil
+}
+
+// String returns the cursor as an opaque string for the API
+func (c *EventCursor) String() string {
+	return base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf("%d:%d:%d", c.Time.Unix(), c.BlockNumber, c.LogIndex)))
+}
+
+// before returns whether the event at blockNumber and logIndex comes before the cursor in chain
+// order, ie after it in a page
+func (c *EventCursor) before(blockNumber int64, logIndex int64) bool {
+	return blockNumber < c.BlockNumber || (blockNumber == c.BlockNumber && logIndex < c.LogIndex)
+}
+
+// SwapFilter picks which swaps GetSwaps returns, empty fields match everything. Swaps come back
+// newest first.
+type SwapFilter struct {
+	// pair, token and wallet addresses. A wallet matches the sender of the tx or who got the tokens out.
+	Pair   string
+	Token  string
+	Wallet string
+
+	// swaps at or after From and before To
+	From time.Time
+	To   time.Time
+
+	MinUSD decimal.Decimal
+
+	// only swaps after this one in the order they're returned, for getting the next page
+	Cursor *EventCursor
+
+	// the most swaps to return, 0 for all of them
+	Limit int
+}
+
+// match returns whether the filter lets s through, for backends that can't do it all in a query
+func (f *SwapFilter) match(s *models.Swap) bool {
+	if f.Pair != "" && s.Address != f.Pair {
+		return false
+	}
+	if f.Token != "" && s.Token0 != f.Token && s.Token1 != f.Token {
+		return false
+	}
+	if f.Wallet != "" && s.TxFrom != f.Wallet && s.To != f.Wallet {
+		return false
+	}
+	if (!f.From.IsZero() && s.Time.Before(f.From)) || (!f.To.IsZero() && !s.Time.Before(f.To)) {
+		return false
+	}
+	if s.ValueUSD.LessThan(f.MinUSD) {
+		return false
+	}
+	return f.Cursor == nil || f.Cursor.before(s.BlockNumber, s.LogIndex)
+}
+
+// page trims swaps to the filter's limit, returning the cursor for the next page if any were cut
+func (f *SwapFilter) page(swaps []*models.Swap) ([]*models.Swap, *EventCursor) {
+	if f.Limit <= 0 || len(swaps) <= f.Limit {
+		return swaps, nil
+	}
+	swaps = swaps[:f.Limit]
+	last := swaps[f.Limit-1]
+	return swaps, NewEventCursor(last.Time, last.BlockNumber, last.LogIndex)
+}
+
+// LiquidityEventFilter picks which mints and burns GetLiquidityEvents returns, empty fields match
+// everything. Events come back newest first.
+type LiquidityEventFilter struct {
+	// pair and wallet addresses. A wallet matches the sender of the tx or who got the LP tokens for a
+	// mint or the underlying tokens for a burn.
+	Pair   string
+	Wallet string
+
+	// models.LiquidityMint or models.LiquidityBurn
+	Type string
+
+	// events at or after From and before To
+	From time.Time
+	To   time.Time
+
+	// only events after this one in the order they're returned, for getting the next page
+	Cursor *EventCursor
+
+	// the most events to return, 0 for all of them
+	Limit int
+}
+
+// page trims events to the filter's limit, returning the cursor for the next page if any were cut
+func (f *LiquidityEventFilter) page(events []*models.LiquidityEvent) ([]*models.LiquidityEvent, *EventCursor) {
+	if f.Limit <= 0 || len(events) <= f.Limit {
+		return events, nil
+	}
+	events = events[:f.Limit]
+	last := events[f.Limit-1]
+	return events, NewEventCursor(last.Time, last.BlockNumber, last.LogIndex)
+}
+
+// match returns whether the filter lets e through, for backends that can't do it all in a query
+func (f *LiquidityEventFilter) match(e *models.LiquidityEvent) bool {
+	if f.Pair != "" && e.Address != f.Pair {
+		return false
+	}
+	if f.Wallet != "" && e.TxFrom != f.Wallet && e.To != f.Wallet {
+		return false
+	}
+	if f.Type != "" && e.Type != f.Type {
+		return false
+	}
+	if (!f.From.IsZero() && e.Time.Before(f.From)) || (!f.To.IsZero() && !e.Time.Before(f.To)) {
+		return false
+	}
+	return f.Cursor == nil || f.Cursor.before(e.BlockNumber, e.LogIndex)
+}
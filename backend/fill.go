@@ -0,0 +1,214 @@
+package backend
+
+import (
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/goswap/stats-api/models"
+)
+
+// Fill is how intervals with no buckets get filled in
+type Fill string
+
+const (
+	// FillZero fills empty intervals with all zeros
+	FillZero Fill = "zero"
+	// FillPrevious fills empty intervals with no flows but the prices and liquidity of the interval
+	// before, since nothing changed them
+	FillPrevious Fill = "previous"
+)
+
+// MaxIntervals is the most intervals a series can be filled to
+const MaxIntervals = 10000
+
+// ErrTooManyIntervals is returned when a series would have more than MaxIntervals intervals
+var ErrTooManyIntervals = errors.New("too many intervals")
+
+// ParseFill returns the fill named s, or false if it isn't one
+func ParseFill(s string) (Fill, bool) {
+	switch f := Fill(s); f {
+	case FillZero, FillPrevious:
+		return f, true
+	}
+	return "", false
+}
+
+// Intervals are all the intervals of a series, in chronological order
+type Intervals struct {
+	// Times are what an empty interval's bucket time is set to
+	Times []time.Time
+
+	// index returns the interval a bucket at t is in
+	index func(t time.Time) int
+}
+
+// NewIntervals returns the intervals from to to at interval, counted back from to like Get* roll
+// them up. An empty interval gets the time of its last hourly bucket, same as the ones with data.
+func NewIntervals(from, to time.Time, interval time.Duration) (*Intervals, error) {
+	f, t, secs := window(from, to, interval)
+	n := (t - f + secs - 1) / secs
+	if n < 0 {
+		n = 0
+	}
+	if n > MaxIntervals {
+		return nil, ErrTooManyIntervals
+	}
+	iv := &Intervals{Times: make([]time.Time, n)}
+	for k := int64(0); k < n; k++ {
+		iv.Times[n-1-k] = time.Unix(t-1-k*secs, 0).Truncate(time.Hour)
+	}
+	iv.index = func(bt time.Time) int {
+		return int(n - 1 - (t-1-bt.Unix())/secs)
+	}
+	return iv, nil
+}
+
+// NewAlignedIntervals returns the windows from to to aligned to the start of each period in loc, like
+// GetTotalsAligned
+func NewAlignedIntervals(from, to time.Time, p Period, loc *time.Location) (*Intervals, error) {
+	w := newWindows(from, to, p, loc)
+	if len(w.starts) > MaxIntervals {
+		return nil, ErrTooManyIntervals
+	}
+	return &Intervals{
+		Times: w.starts,
+		index: func(t time.Time) int {
+			return sort.Search(len(w.starts), func(i int) bool { return w.starts[i].After(t) }) - 1
+		},
+	}, nil
+}
+
+// slot returns the interval a bucket at t goes in, ones just out of range go in the nearest
+func (iv *Intervals) slot(t time.Time) int {
+	i := iv.index(t)
+	if i < 0 {
+		return 0
+	}
+	if i >= len(iv.Times) {
+		return len(iv.Times) - 1
+	}
+	return i
+}
+
+// FillPairBuckets returns each pair's buckets with a bucket for every interval that didn't have one.
+// If address is set, it gets a series even if it has no buckets at all.
+func FillPairBuckets(pbs []*models.PairBucket, iv *Intervals, fill Fill, address string) []*models.PairBucket {
+	if len(iv.Times) == 0 {
+		return pbs
+	}
+	series := map[string][][]*models.PairBucket{}
+	var addresses []string
+	if address != "" {
+		series[address] = make([][]*models.PairBucket, len(iv.Times))
+		addresses = append(addresses, address)
+	}
+	sort.SliceStable(pbs, func(i, j int) bool { return pbs[i].Time.Before(pbs[j].Time) })
+	for _, pb := range pbs {
+		s := series[pb.Address]
+		if s == nil {
+			s = make([][]*models.PairBucket, len(iv.Times))
+			series[pb.Address] = s
+			addresses = append(addresses, pb.Address)
+		}
+		i := iv.slot(pb.Time)
+		s[i] = append(s[i], pb)
+	}
+
+	ret := make([]*models.PairBucket, 0, len(addresses)*len(iv.Times))
+	for _, a := range addresses {
+		// the pair's name, from any of its buckets
+		empty := &models.PairBucket{Address: a}
+		for _, b := range series[a] {
+			if len(b) > 0 {
+				empty.Pair = b[0].Pair
+				break
+			}
+		}
+		for i, b := range series[a] {
+			if len(b) > 0 {
+				ret = append(ret, b...)
+				if fill == FillPrevious {
+					empty = b[len(b)-1]
+				}
+				continue
+			}
+			ret = append(ret, empty.Empty(iv.Times[i]))
+		}
+	}
+	return ret
+}
+
+// FillTokenBuckets returns each token's buckets with a bucket for every interval that didn't have
+// one, like FillPairBuckets
+func FillTokenBuckets(tbs []*models.TokenBucket, iv *Intervals, fill Fill, address string) []*models.TokenBucket {
+	if len(iv.Times) == 0 {
+		return tbs
+	}
+	series := map[string][][]*models.TokenBucket{}
+	var addresses []string
+	if address != "" {
+		series[address] = make([][]*models.TokenBucket, len(iv.Times))
+		addresses = append(addresses, address)
+	}
+	sort.SliceStable(tbs, func(i, j int) bool { return tbs[i].Time.Before(tbs[j].Time) })
+	for _, tb := range tbs {
+		s := series[tb.Address]
+		if s == nil {
+			s = make([][]*models.TokenBucket, len(iv.Times))
+			series[tb.Address] = s
+			addresses = append(addresses, tb.Address)
+		}
+		i := iv.slot(tb.Time)
+		s[i] = append(s[i], tb)
+	}
+
+	ret := make([]*models.TokenBucket, 0, len(addresses)*len(iv.Times))
+	for _, a := range addresses {
+		empty := &models.TokenBucket{Address: a}
+		for _, b := range series[a] {
+			if len(b) > 0 {
+				empty.Symbol = b[0].Symbol
+				break
+			}
+		}
+		for i, b := range series[a] {
+			if len(b) > 0 {
+				ret = append(ret, b...)
+				if fill == FillPrevious {
+					empty = b[len(b)-1]
+				}
+				continue
+			}
+			ret = append(ret, empty.Empty(iv.Times[i]))
+		}
+	}
+	return ret
+}
+
+// FillTotals returns the totals with a bucket for every interval that didn't have one
+func FillTotals(totals []*models.TotalBucket, iv *Intervals, fill Fill) []*models.TotalBucket {
+	if len(iv.Times) == 0 {
+		return totals
+	}
+	slots := make([][]*models.TotalBucket, len(iv.Times))
+	sort.SliceStable(totals, func(i, j int) bool { return totals[i].Time.Before(totals[j].Time) })
+	for _, t := range totals {
+		i := iv.slot(t.Time)
+		slots[i] = append(slots[i], t)
+	}
+
+	ret := make([]*models.TotalBucket, 0, len(iv.Times))
+	empty := &models.TotalBucket{}
+	for i, b := range slots {
+		if len(b) > 0 {
+			ret = append(ret, b...)
+			if fill == FillPrevious {
+				empty = b[len(b)-1]
+			}
+			continue
+		}
+		ret = append(ret, empty.Empty(iv.Times[i]))
+	}
+	return ret
+}
@@ -2,18 +2,22 @@ package backend
 
 import (
 	"context"
+	"fmt"
 	"time"
 
 	"cloud.google.com/go/firestore"
 	"github.com/goswap/stats-api/models"
 	"github.com/treeder/gotils"
 	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 // TODO we probably want to set a max of data to return? or do something to prevent returning 3 years at 1 second, for example.
 
 const (
 	CollectionTimestamps = "timestamps"
+	CollectionPairChecks = "pair_checks"
 
 	CollectionPairs  = "pairs"
 	CollectionTokens = "tokens"
@@ -144,7 +148,7 @@ func (fs *FirestoreBackend) GetToken(ctx context.Context, address string) (*mode
 func (fs *FirestoreBackend) GetTotals(ctx context.Context, from, to time.Time, interval time.Duration) ([]*models.TotalBucket, error) {
 	var totals []*models.TotalBucket
 
-	c := fs.c.Collection(CollectionTotals)
+	c := fs.c.Collection(rollupCollection(CollectionTotals, resolutionFor(from, to, interval)))
 	q := c.Query
 	if !to.IsZero() {
 		q = q.Where("time", "<", to)
@@ -172,7 +176,7 @@ func (fs *FirestoreBackend) GetTotals(ctx context.Context, from, to time.Time, i
 		totals = append(totals, t)
 	}
 
-	// TODO this should be removed for pulling from aggregated data at given intervals
+	// reading from the coarsest rollup that fits, these just get summed up into interval from there
 	// we have to go backwards to sum, to align windows for now, but still insert in chronological order
 	var ie *models.TotalBucket
 	var originalEnd time.Time
@@ -194,7 +198,7 @@ func (fs *FirestoreBackend) GetTotals(ctx context.Context, from, to time.Time, i
 			ie = t
 		} else {
 			// add volume
-			ie.VolumeUSD = ie.VolumeUSD.Add(t.VolumeUSD)
+			ie.AddFlows(t)
 			// liquidity is just the last data point in any hour (don't add)
 			// ie.LiquidityUSD = t.LiquidityUSD
 		}
@@ -211,7 +215,7 @@ func (fs *FirestoreBackend) GetTotals(ctx context.Context, from, to time.Time, i
 }
 
 func (fs *FirestoreBackend) GetPairBuckets(ctx context.Context, pair string, from, to time.Time, interval time.Duration) ([]*models.PairBucket, error) {
-	c := fs.c.Collection(CollectionPairBuckets)
+	c := fs.c.Collection(rollupCollection(CollectionPairBuckets, resolutionFor(from, to, interval)))
 	q := c.Query
 	if pair != "" {
 		q = q.Where("address", "==", pair)
@@ -249,7 +253,7 @@ func (fs *FirestoreBackend) GetPairBuckets(ctx context.Context, pair string, fro
 	// we want to return empty list and not null + size here
 	pairs := make([]*models.PairBucket, 0, n)
 
-	// TODO this should be removed for pulling from aggregated data at given interva
+	// reading from the coarsest rollup that fits, these just get summed up into interval from there
 	// we have to go backwards to sum, to align windows for now, but still insert in chronological order
 	for _, pair := range pbs {
 		var ie *models.PairBucket
@@ -270,20 +274,10 @@ func (fs *FirestoreBackend) GetPairBuckets(ctx context.Context, pair string, fro
 				pairs = append([]*models.PairBucket{ie}, pairs...)
 				ie = p
 			} else {
-				// add volume stuff
-				ie.Amount0In = ie.Amount0In.Add(p.Amount0In)
-				ie.Amount1In = ie.Amount1In.Add(p.Amount1In)
-				ie.Amount0Out = ie.Amount0Out.Add(p.Amount0Out)
-				ie.Amount1Out = ie.Amount1Out.Add(p.Amount1Out)
-				ie.VolumeUSD = ie.VolumeUSD.Add(p.VolumeUSD)
-
-				// liquidity/price is just the last data point in any hour (don't add)
-				//ie.Price0USD = p.Price0USD
-				//ie.Price1USD = p.Price1USD
-				//ie.TotalSupply = p.TotalSupply
-				//ie.Reserve0 = p.Reserve0
-				//ie.Reserve1 = p.Reserve1
-				//ie.LiquidityUSD = p.LiquidityUSD
+				// add volume stuff, liquidity/price is just the last data point in any hour (don't add)
+				ie.AddFlows(p)
+				// p is earlier, so it opens the candle
+				ie.OHLC0 = p.OHLC0.Merge(ie.OHLC0)
 			}
 		}
 
@@ -299,7 +293,7 @@ func (fs *FirestoreBackend) GetPairBuckets(ctx context.Context, pair string, fro
 }
 
 func (fs *FirestoreBackend) GetTokenBuckets(ctx context.Context, token string, from, to time.Time, interval time.Duration) ([]*models.TokenBucket, error) {
-	c := fs.c.Collection(CollectionTokenBuckets)
+	c := fs.c.Collection(rollupCollection(CollectionTokenBuckets, resolutionFor(from, to, interval)))
 	q := c.Query
 	if token != "" {
 		q = q.Where("address", "==", token)
@@ -337,7 +331,7 @@ func (fs *FirestoreBackend) GetTokenBuckets(ctx context.Context, token string, f
 	// want to default to empty list, but also size
 	tokens := make([]*models.TokenBucket, 0, n)
 
-	// TODO this should be removed for pulling from aggregated data at given interva
+	// reading from the coarsest rollup that fits, these just get summed up into interval from there
 	// we have to go backwards to sum, to align windows for now, but still insert in chronological order
 	for _, tok := range tbs {
 		var ie *models.TokenBucket
@@ -358,15 +352,9 @@ func (fs *FirestoreBackend) GetTokenBuckets(ctx context.Context, token string, f
 				tokens = append([]*models.TokenBucket{ie}, tokens...)
 				ie = t
 			} else {
-				// add volume stuff
-				ie.AmountIn = ie.AmountIn.Add(t.AmountIn)
-				ie.AmountOut = ie.AmountOut.Add(t.AmountOut)
-				ie.VolumeUSD = ie.VolumeUSD.Add(t.VolumeUSD)
-
-				// dont' add these
-				//ie.PriceUSD = t.PriceUSD
-				//ie.Reserve = t.Reserve
-				//ie.LiquidityUSD = t.LiquidityUSD
+				// add volume stuff, price and reserve are just the last data point (don't add)
+				ie.AddFlows(t)
+				ie.OHLCUSD = t.OHLCUSD.Merge(ie.OHLCUSD)
 			}
 		}
 
@@ -380,3 +368,356 @@ func (fs *FirestoreBackend) GetTokenBuckets(ctx context.Context, token string, f
 
 	return tokens, nil
 }
+
+// maxEventReads is the most swaps or liquidity events GetSwaps and GetLiquidityEvents read for one
+// page. Filters that Firestore can't query on are applied as the results stream in, so a page that
+// hits this returns what it found so far and a cursor to carry on from.
+const maxEventReads = 1000
+
+// GetSwaps returns the swaps that match the filter, newest first. Firestore only takes one
+// array-contains per query and can't compare the string amounts, so the rest of the filter is
+// applied as the results stream in, up to maxEventReads swaps.
+//
+// The ordering needs a composite index on swaps for each combination of equality filters used:
+// (time desc, blockNumber desc, logIndex desc) with none, and prefixed by address, tokens
+// (array-contains), wallets (array-contains), address and tokens, or address and wallets.
+func (fs *FirestoreBackend) GetSwaps(ctx context.Context, f *SwapFilter) ([]*models.Swap, *EventCursor, error) {
+	q := fs.c.Collection(CollectionSwaps).Query
+	if f.Pair != "" {
+		q = q.Where("address", "==", f.Pair)
+	}
+	if f.Token != "" {
+		q = q.Where("tokens", "array-contains", f.Token)
+	} else if f.Wallet != "" {
+		q = q.Where("wallets", "array-contains", f.Wallet)
+	}
+	if !f.From.IsZero() {
+		q = q.Where("time", ">=", f.From)
+	}
+	if !f.To.IsZero() {
+		q = q.Where("time", "<", f.To)
+	}
+	q = q.OrderBy("time", firestore.Desc).
+		OrderBy("blockNumber", firestore.Desc).
+		OrderBy("logIndex", firestore.Desc)
+	if f.Cursor != nil {
+		q = q.StartAfter(f.Cursor.Time, f.Cursor.BlockNumber, f.Cursor.LogIndex)
+	}
+	iter := q.Limit(maxEventReads).Documents(ctx)
+	defer iter.Stop()
+
+	swaps := make([]*models.Swap, 0)
+	var last *models.Swap
+	reads := 0
+	for f.Limit <= 0 || len(swaps) <= f.Limit {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, nil, gotils.C(ctx).Errorf("error getting data: %v", err)
+		}
+		reads++
+		s := new(models.Swap)
+		err = doc.DataTo(s)
+		if err != nil {
+			return nil, nil, gotils.C(ctx).Errorf("%v", err)
+		}
+		s.AfterLoad(ctx)
+		last = s
+		if f.match(s) {
+			swaps = append(swaps, s)
+		}
+	}
+	swaps, next := f.page(swaps)
+	if next == nil && reads == maxEventReads {
+		// there may be more that match past what was read
+		next = NewEventCursor(last.Time, last.BlockNumber, last.LogIndex)
+	}
+	return swaps, next, nil
+}
+
+// GetLiquidityEvents returns the mints and burns that match the filter, newest first, filtering the
+// rest as the results stream in like GetSwaps, up to maxEventReads events.
+//
+// The ordering needs a composite index on liquidity_events of (time desc, blockNumber desc,
+// logIndex desc) for each combination of the address, wallets (array-contains) and type filters used.
+func (fs *FirestoreBackend) GetLiquidityEvents(ctx context.Context, f *LiquidityEventFilter) ([]*models.LiquidityEvent, *EventCursor, error) {
+	q := fs.c.Collection(CollectionLiquidityEvents).Query
+	if f.Pair != "" {
+		q = q.Where("address", "==", f.Pair)
+	}
+	if f.Wallet != "" {
+		q = q.Where("wallets", "array-contains", f.Wallet)
+	}
+	if f.Type != "" {
+		q = q.Where("type", "==", f.Type)
+	}
+	if !f.From.IsZero() {
+		q = q.Where("time", ">=", f.From)
+	}
+	if !f.To.IsZero() {
+		q = q.Where("time", "<", f.To)
+	}
+	q = q.OrderBy("time", firestore.Desc).
+		OrderBy("blockNumber", firestore.Desc).
+		OrderBy("logIndex", firestore.Desc)
+	if f.Cursor != nil {
+		q = q.StartAfter(f.Cursor.Time, f.Cursor.BlockNumber, f.Cursor.LogIndex)
+	}
+	iter := q.Limit(maxEventReads).Documents(ctx)
+	defer iter.Stop()
+
+	events := make([]*models.LiquidityEvent, 0)
+	var last *models.LiquidityEvent
+	reads := 0
+	for f.Limit <= 0 || len(events) <= f.Limit {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, nil, gotils.C(ctx).Errorf("error getting data: %v", err)
+		}
+		reads++
+		e := new(models.LiquidityEvent)
+		err = doc.DataTo(e)
+		if err != nil {
+			return nil, nil, gotils.C(ctx).Errorf("%v", err)
+		}
+		e.AfterLoad(ctx)
+		last = e
+		if f.match(e) {
+			events = append(events, e)
+		}
+	}
+	events, next := f.page(events)
+	if next == nil && reads == maxEventReads {
+		next = NewEventCursor(last.Time, last.BlockNumber, last.LogIndex)
+	}
+	return events, next, nil
+}
+
+// SavePair stores the pair, keyed by its address
+func (fs *FirestoreBackend) SavePair(ctx context.Context, p *models.Pair) error {
+	p.PreSave()
+	_, err := fs.c.Collection(CollectionPairs).Doc(p.Address.Hex()).Set(ctx, p)
+	if err != nil {
+		return gotils.C(ctx).Errorf("error storing pair: %v", err)
+	}
+	return nil
+}
+
+// SaveToken stores the token, keyed by its address
+func (fs *FirestoreBackend) SaveToken(ctx context.Context, t *models.Token) error {
+	t.PreSave()
+	_, err := fs.c.Collection(CollectionTokens).Doc(t.Address.Hex()).Set(ctx, t)
+	if err != nil {
+		return gotils.C(ctx).Errorf("error storing token: %v", err)
+	}
+	return nil
+}
+
+// SavePairBucket stores the bucket, keyed by pair address and time
+func (fs *FirestoreBackend) SavePairBucket(ctx context.Context, b *models.PairBucket) error {
+	return fs.savePairBucket(ctx, CollectionPairBuckets, b)
+}
+
+func (fs *FirestoreBackend) savePairBucket(ctx context.Context, collection string, b *models.PairBucket) error {
+	b.PreSave()
+	_, err := fs.c.Collection(collection).Doc(fmt.Sprintf("%v_%v", b.Address, b.Time.Unix())).Set(ctx, b)
+	if err != nil {
+		return gotils.C(ctx).Errorf("error writing to db: %v", err)
+	}
+	return nil
+}
+
+// SaveTokenBucket stores the bucket, keyed by token address and time
+func (fs *FirestoreBackend) SaveTokenBucket(ctx context.Context, b *models.TokenBucket) error {
+	return fs.saveTokenBucket(ctx, CollectionTokenBuckets, b)
+}
+
+func (fs *FirestoreBackend) saveTokenBucket(ctx context.Context, collection string, b *models.TokenBucket) error {
+	b.PreSave()
+	_, err := fs.c.Collection(collection).Doc(fmt.Sprintf("%v_%v", b.Address, b.Time.Unix())).Set(ctx, b)
+	if err != nil {
+		return gotils.C(ctx).Errorf("error writing to db: %v", err)
+	}
+	return nil
+}
+
+// SaveTotalBucket stores the bucket, keyed by time
+func (fs *FirestoreBackend) SaveTotalBucket(ctx context.Context, b *models.TotalBucket) error {
+	return fs.saveTotalBucket(ctx, CollectionTotals, b)
+}
+
+func (fs *FirestoreBackend) saveTotalBucket(ctx context.Context, collection string, b *models.TotalBucket) error {
+	b.PreSave()
+	_, err := fs.c.Collection(collection).Doc(fmt.Sprintf("%v", b.Time.Unix())).Set(ctx, b)
+	if err != nil {
+		return gotils.C(ctx).Errorf("error writing to db: %v", err)
+	}
+	return nil
+}
+
+// DeleteBuckets removes all hourly buckets at or after from and before to, so they can be recomputed from scratch
+func (fs *FirestoreBackend) DeleteBuckets(ctx context.Context, from, to time.Time) error {
+	for _, c := range []string{CollectionPairBuckets, CollectionTokenBuckets, CollectionTotals} {
+		q := fs.c.Collection(c).Where("time", ">=", from)
+		if !to.IsZero() {
+			q = q.Where("time", "<", to)
+		}
+		n, err := deleteDocs(ctx, q)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("deleted %v docs from %v between %v and %v\n", n, c, from, to)
+	}
+	return nil
+}
+
+// deleteDocs deletes everything the query returns and returns how many
+func deleteDocs(ctx context.Context, q firestore.Query) (int, error) {
+	iter := q.Documents(ctx)
+	defer iter.Stop()
+	n := 0
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return n, gotils.C(ctx).Errorf("error getting data: %v", err)
+		}
+		_, err = doc.Ref.Delete(ctx)
+		if err != nil {
+			return n, gotils.C(ctx).Errorf("error deleting %v: %v", doc.Ref.Path, err)
+		}
+		n++
+	}
+	return n, nil
+}
+
+// ReplaceRollups deletes the buckets at resolution for the period starting at start and stores the
+// given ones in their place, keyed the same way as the hourly ones
+func (fs *FirestoreBackend) ReplaceRollups(ctx context.Context, resolution time.Duration, start time.Time, pbs []*models.PairBucket, tbs []*models.TokenBucket, totals []*models.TotalBucket) error {
+	for _, c := range []string{CollectionPairBuckets, CollectionTokenBuckets, CollectionTotals} {
+		_, err := deleteDocs(ctx, fs.c.Collection(rollupCollection(c, resolution)).Where("time", "==", start))
+		if err != nil {
+			return err
+		}
+	}
+	for _, b := range pbs {
+		err := fs.savePairBucket(ctx, rollupCollection(CollectionPairBuckets, resolution), b)
+		if err != nil {
+			return err
+		}
+	}
+	for _, b := range tbs {
+		err := fs.saveTokenBucket(ctx, rollupCollection(CollectionTokenBuckets, resolution), b)
+		if err != nil {
+			return err
+		}
+	}
+	for _, b := range totals {
+		err := fs.saveTotalBucket(ctx, rollupCollection(CollectionTotals, resolution), b)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReplaceSwaps deletes the pair's swaps between from and to and stores the given ones, keyed by tx
+// hash and log index
+func (fs *FirestoreBackend) ReplaceSwaps(ctx context.Context, pair string, from, to time.Time, swaps []*models.Swap) error {
+	q := fs.c.Collection(CollectionSwaps).Where("address", "==", pair).Where("time", ">=", from).Where("time", "<", to)
+	_, err := deleteDocs(ctx, q)
+	if err != nil {
+		return err
+	}
+	for _, s := range swaps {
+		s.PreSave()
+		_, err := fs.c.Collection(CollectionSwaps).Doc(fmt.Sprintf("%v_%v", s.TxHash, s.LogIndex)).Set(ctx, s)
+		if err != nil {
+			return gotils.C(ctx).Errorf("error writing to db: %v", err)
+		}
+	}
+	return nil
+}
+
+// ReplaceLiquidityEvents deletes the pair's mints and burns between from and to and stores the given
+// ones, keyed by tx hash and log index
+func (fs *FirestoreBackend) ReplaceLiquidityEvents(ctx context.Context, pair string, from, to time.Time, events []*models.LiquidityEvent) error {
+	q := fs.c.Collection(CollectionLiquidityEvents).Where("address", "==", pair).Where("time", ">=", from).Where("time", "<", to)
+	_, err := deleteDocs(ctx, q)
+	if err != nil {
+		return err
+	}
+	for _, e := range events {
+		e.PreSave()
+		_, err := fs.c.Collection(CollectionLiquidityEvents).Doc(fmt.Sprintf("%v_%v", e.TxHash, e.LogIndex)).Set(ctx, e)
+		if err != nil {
+			return gotils.C(ctx).Errorf("error writing to db: %v", err)
+		}
+	}
+	return nil
+}
+
+// GetLastCheck returns the collector's global checkpoint, or an empty one if there isn't one yet
+func (fs *FirestoreBackend) GetLastCheck(ctx context.Context) (*models.LastCheck, error) {
+	lc := &models.LastCheck{}
+	dsnap, err := fs.c.Collection(CollectionTimestamps).Doc("last_check").Get(ctx)
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			return lc, nil
+		}
+		return nil, gotils.C(ctx).Errorf("error getting lastcheck: %v", err)
+	}
+	err = dsnap.DataTo(lc)
+	if err != nil {
+		return nil, gotils.C(ctx).Errorf("Failed to DataTo: %v", err)
+	}
+	return lc, nil
+}
+
+// SaveLastCheck stores the collector's global checkpoint
+func (fs *FirestoreBackend) SaveLastCheck(ctx context.Context, lc *models.LastCheck) error {
+	_, err := fs.c.Collection(CollectionTimestamps).Doc("last_check").Set(ctx, lc)
+	if err != nil {
+		return gotils.C(ctx).Errorf("error saving last check: %v", err)
+	}
+	return nil
+}
+
+// GetPairChecks returns the collector's checkpoint for each pair, by pair address
+func (fs *FirestoreBackend) GetPairChecks(ctx context.Context) (map[string]*models.PairCheck, error) {
+	pcs := map[string]*models.PairCheck{}
+	iter := fs.c.Collection(CollectionPairChecks).Documents(ctx)
+	defer iter.Stop()
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, gotils.C(ctx).Errorf("error getting data: %v", err)
+		}
+		pc := new(models.PairCheck)
+		err = doc.DataTo(pc)
+		if err != nil {
+			return nil, gotils.C(ctx).Errorf("%v", err)
+		}
+		pcs[pc.Address] = pc
+	}
+	return pcs, nil
+}
+
+// SavePairCheck stores the collector's checkpoint for a pair, keyed by pair address
+func (fs *FirestoreBackend) SavePairCheck(ctx context.Context, pc *models.PairCheck) error {
+	_, err := fs.c.Collection(CollectionPairChecks).Doc(pc.Address).Set(ctx, pc)
+	if err != nil {
+		return gotils.C(ctx).Errorf("error storing pair check: %v", err)
+	}
+	return nil
+}
@@ -37,4 +37,54 @@ type StatsBackend interface {
 	// in the given time window at the given duration (eg per minute, per day,
 	// etc).
 	GetTokenBuckets(ctx context.Context, token string, from, to time.Time, interval time.Duration) ([]*models.TokenBucket, error)
+
+	// GetSwaps returns the swaps that match the filter, newest first, and the cursor for the next
+	// page if there may be more.
+	GetSwaps(ctx context.Context, f *SwapFilter) ([]*models.Swap, *EventCursor, error)
+
+	// GetLiquidityEvents returns the mints and burns that match the filter, newest first, and the
+	// cursor for the next page if there may be more.
+	GetLiquidityEvents(ctx context.Context, f *LiquidityEventFilter) ([]*models.LiquidityEvent, *EventCursor, error)
+}
+
+// StatsWriter defines methods for storing goswap statistics, this is what the
+// collector writes to. Saving a bucket overwrites any bucket already stored for
+// the same address and time.
+type StatsWriter interface {
+	SavePair(ctx context.Context, p *models.Pair) error
+	SaveToken(ctx context.Context, t *models.Token) error
+
+	SavePairBucket(ctx context.Context, b *models.PairBucket) error
+	SaveTokenBucket(ctx context.Context, b *models.TokenBucket) error
+	SaveTotalBucket(ctx context.Context, b *models.TotalBucket) error
+
+	// DeleteBuckets removes all hourly pair, token and total buckets at or after from
+	// and before to. A zero to means no end.
+	DeleteBuckets(ctx context.Context, from, to time.Time) error
+
+	// ReplaceRollups replaces all the buckets stored at resolution (Day or
+	// Week) for the period starting at start with the given ones, so buckets
+	// that don't belong in it anymore don't stick around.
+	ReplaceRollups(ctx context.Context, resolution time.Duration, start time.Time, pbs []*models.PairBucket, tbs []*models.TokenBucket, totals []*models.TotalBucket) error
+
+	// ReplaceSwaps replaces all the swaps stored for pair at or after from and
+	// before to with the given ones, so swaps that got reorged out go away.
+	ReplaceSwaps(ctx context.Context, pair string, from, to time.Time, swaps []*models.Swap) error
+	// ReplaceLiquidityEvents does the same for mints and burns.
+	ReplaceLiquidityEvents(ctx context.Context, pair string, from, to time.Time, events []*models.LiquidityEvent) error
+
+	// GetLastCheck returns the collector's global checkpoint, or an empty one if
+	// it hasn't run yet.
+	GetLastCheck(ctx context.Context) (*models.LastCheck, error)
+	SaveLastCheck(ctx context.Context, lc *models.LastCheck) error
+
+	// GetPairChecks returns the collector's checkpoint for each pair, by pair address.
+	GetPairChecks(ctx context.Context) (map[string]*models.PairCheck, error)
+	SavePairCheck(ctx context.Context, pc *models.PairCheck) error
+}
+
+// Backend is a StatsBackend that can also be written to.
+type Backend interface {
+	StatsBackend
+	StatsWriter
 }
@@ -15,11 +15,19 @@ type mock struct {
 	pairBuckets  []*models.PairBucket
 	tokenBuckets []*models.TokenBucket
 	totalBuckets []*models.TotalBucket
+	swaps        []*models.Swap
+	liquidity    []*models.LiquidityEvent
+
+	lastCheck  *models.LastCheck
+	pairChecks map[string]*models.PairCheck
+
+	// buckets at the coarser resolutions, by resolution. nil for the mocks holding those.
+	rollups map[time.Duration]*mock
 }
 
 // NewMock returns a mock database, for use in testing
-func NewMock(args ...interface{}) StatsBackend {
-	m := new(mock)
+func NewMock(args ...interface{}) Backend {
+	m := &mock{lastCheck: &models.LastCheck{}, pairChecks: map[string]*models.PairCheck{}, rollups: map[time.Duration]*mock{}}
 	for _, arg := range args {
 		switch arg := arg.(type) {
 		case []*models.Pair:
@@ -44,6 +52,10 @@ func NewMock(args ...interface{}) StatsBackend {
 				return arg[i].Time.Before(arg[j].Time)
 			})
 			m.totalBuckets = arg
+		case []*models.Swap:
+			m.swaps = arg
+		case []*models.LiquidityEvent:
+			m.liquidity = arg
 		default:
 			panic("unsupported type for mock db, double check your code?")
 		}
@@ -77,7 +89,23 @@ func (m *mock) GetToken(ctx context.Context, address string) (*models.Token, err
 	return nil, errors.New("TODO: token not found error")
 }
 
+// rollup returns the mock holding the buckets at resolution
+func (m *mock) rollup(resolution time.Duration) *mock {
+	if resolution == Resolutions[0] {
+		return m
+	}
+	r := m.rollups[resolution]
+	if r == nil {
+		r = &mock{}
+		m.rollups[resolution] = r
+	}
+	return r
+}
+
 func (m *mock) GetTotals(ctx context.Context, from, to time.Time, interval time.Duration) ([]*models.TotalBucket, error) {
+	if res := resolutionFor(from, to, interval); m.rollups != nil && res != Resolutions[0] {
+		return m.rollup(res).GetTotals(ctx, from, to, interval)
+	}
 	var totals []*models.TotalBucket
 	var ie *models.TotalBucket
 	for _, t := range m.totalBuckets {
@@ -92,10 +120,8 @@ func (m *mock) GetTotals(ctx context.Context, from, to time.Time, interval time.
 			totals = append(totals, ie)
 			ie = t
 		} else {
-			// add volume
-			ie.VolumeUSD = ie.VolumeUSD.Add(t.VolumeUSD)
-			// liquidity is just the last data point in any hour (don't add)
-			ie.LiquidityUSD = t.LiquidityUSD
+			// add volume, liquidity is just the last data point in any hour (don't add)
+			ie.Add(t)
 		}
 	}
 
@@ -107,6 +133,9 @@ func (m *mock) GetTotals(ctx context.Context, from, to time.Time, interval time.
 }
 
 func (m *mock) GetPairBuckets(ctx context.Context, address string, from, to time.Time, interval time.Duration) ([]*models.PairBucket, error) {
+	if res := resolutionFor(from, to, interval); m.rollups != nil && res != Resolutions[0] {
+		return m.rollup(res).GetPairBuckets(ctx, address, from, to, interval)
+	}
 	pbs := make(map[string][]*models.PairBucket)
 	for _, p := range m.pairBuckets {
 		if (address != "" && address != p.Address) || p.Time.Before(from) || to.Before(p.Time) {
@@ -126,20 +155,8 @@ func (m *mock) GetPairBuckets(ctx context.Context, address string, from, to time
 			// shift the window
 			pbs[p.Address] = append(cp, p)
 		} else {
-			// add volume stuff
-			ie.Amount0In = ie.Amount0In.Add(p.Amount0In)
-			ie.Amount1In = ie.Amount1In.Add(p.Amount1In)
-			ie.Amount0Out = ie.Amount0Out.Add(p.Amount0Out)
-			ie.Amount1Out = ie.Amount1Out.Add(p.Amount1Out)
-			ie.VolumeUSD = ie.VolumeUSD.Add(p.VolumeUSD)
-
-			// liquidity/price is just the last data point in any hour (don't add)
-			ie.Price0USD = p.Price0USD
-			ie.Price1USD = p.Price1USD
-			ie.TotalSupply = p.TotalSupply
-			ie.Reserve0 = p.Reserve0
-			ie.Reserve1 = p.Reserve1
-			ie.LiquidityUSD = p.LiquidityUSD
+			// add volume stuff, liquidity/price is just the last data point in any hour (don't add)
+			ie.Add(p)
 		}
 	}
 
@@ -151,6 +168,9 @@ func (m *mock) GetPairBuckets(ctx context.Context, address string, from, to time
 }
 
 func (m *mock) GetTokenBuckets(ctx context.Context, address string, from, to time.Time, interval time.Duration) ([]*models.TokenBucket, error) {
+	if res := resolutionFor(from, to, interval); m.rollups != nil && res != Resolutions[0] {
+		return m.rollup(res).GetTokenBuckets(ctx, address, from, to, interval)
+	}
 	tbs := make(map[string][]*models.TokenBucket)
 	for _, t := range m.tokenBuckets {
 		if (address != "" && address != t.Address) || t.Time.Before(from) || to.Before(t.Time) {
@@ -169,15 +189,8 @@ func (m *mock) GetTokenBuckets(ctx context.Context, address string, from, to tim
 			// shift the window
 			tbs[t.Address] = append(ct, t)
 		} else {
-			// add volume stuff
-			ie.AmountIn = ie.AmountIn.Add(t.AmountIn)
-			ie.AmountOut = ie.AmountOut.Add(t.AmountOut)
-			ie.VolumeUSD = ie.VolumeUSD.Add(t.VolumeUSD)
-
-			// dont' add these
-			ie.PriceUSD = t.PriceUSD
-			ie.Reserve = t.Reserve
-			ie.LiquidityUSD = t.LiquidityUSD
+			// add volume stuff, price and reserve are just the last data point (don't add)
+			ie.Add(t)
 		}
 	}
 
@@ -188,3 +201,205 @@ func (m *mock) GetTokenBuckets(ctx context.Context, address string, from, to tim
 
 	return tokens, nil
 }
+
+func (m *mock) GetSwaps(ctx context.Context, f *SwapFilter) ([]*models.Swap, *EventCursor, error) {
+	swaps := make([]*models.Swap, 0)
+	for _, s := range m.swaps {
+		if f.match(s) {
+			swaps = append(swaps, s)
+		}
+	}
+	// newest first, like the db
+	sort.Slice(swaps, func(i, j int) bool {
+		if swaps[i].BlockNumber != swaps[j].BlockNumber {
+			return swaps[i].BlockNumber > swaps[j].BlockNumber
+		}
+		return swaps[i].LogIndex > swaps[j].LogIndex
+	})
+	swaps, next := f.page(swaps)
+	return swaps, next, nil
+}
+
+func (m *mock) GetLiquidityEvents(ctx context.Context, f *LiquidityEventFilter) ([]*models.LiquidityEvent, *EventCursor, error) {
+	events := make([]*models.LiquidityEvent, 0)
+	for _, e := range m.liquidity {
+		if f.match(e) {
+			events = append(events, e)
+		}
+	}
+	sort.Slice(events, func(i, j int) bool {
+		if events[i].BlockNumber != events[j].BlockNumber {
+			return events[i].BlockNumber > events[j].BlockNumber
+		}
+		return events[i].LogIndex > events[j].LogIndex
+	})
+	events, next := f.page(events)
+	return events, next, nil
+}
+
+func (m *mock) SavePair(ctx context.Context, p *models.Pair) error {
+	p.PreSave()
+	for i, p2 := range m.pairs {
+		if p2.Address == p.Address {
+			m.pairs[i] = p
+			return nil
+		}
+	}
+	m.pairs = append(m.pairs, p)
+	sort.Slice(m.pairs, func(i, j int) bool {
+		return m.pairs[i].Index < m.pairs[j].Index
+	})
+	return nil
+}
+
+func (m *mock) SaveToken(ctx context.Context, t *models.Token) error {
+	t.PreSave()
+	for i, t2 := range m.tokens {
+		if t2.Address == t.Address {
+			m.tokens[i] = t
+			return nil
+		}
+	}
+	m.tokens = append(m.tokens, t)
+	return nil
+}
+
+func (m *mock) SavePairBucket(ctx context.Context, b *models.PairBucket) error {
+	for i, b2 := range m.pairBuckets {
+		if b2.Address == b.Address && b2.Time.Equal(b.Time) {
+			m.pairBuckets[i] = b
+			return nil
+		}
+	}
+	m.pairBuckets = append(m.pairBuckets, b)
+	// keep sorted by time, like we use in db
+	sort.SliceStable(m.pairBuckets, func(i, j int) bool {
+		return m.pairBuckets[i].Time.Before(m.pairBuckets[j].Time)
+	})
+	return nil
+}
+
+func (m *mock) SaveTokenBucket(ctx context.Context, b *models.TokenBucket) error {
+	for i, b2 := range m.tokenBuckets {
+		if b2.Address == b.Address && b2.Time.Equal(b.Time) {
+			m.tokenBuckets[i] = b
+			return nil
+		}
+	}
+	m.tokenBuckets = append(m.tokenBuckets, b)
+	sort.SliceStable(m.tokenBuckets, func(i, j int) bool {
+		return m.tokenBuckets[i].Time.Before(m.tokenBuckets[j].Time)
+	})
+	return nil
+}
+
+func (m *mock) SaveTotalBucket(ctx context.Context, b *models.TotalBucket) error {
+	for i, b2 := range m.totalBuckets {
+		if b2.Time.Equal(b.Time) {
+			m.totalBuckets[i] = b
+			return nil
+		}
+	}
+	m.totalBuckets = append(m.totalBuckets, b)
+	sort.SliceStable(m.totalBuckets, func(i, j int) bool {
+		return m.totalBuckets[i].Time.Before(m.totalBuckets[j].Time)
+	})
+	return nil
+}
+
+func (m *mock) DeleteBuckets(ctx context.Context, from, to time.Time) error {
+	in := func(t time.Time) bool {
+		return !t.Before(from) && (to.IsZero() || t.Before(to))
+	}
+	var pbs []*models.PairBucket
+	for _, b := range m.pairBuckets {
+		if !in(b.Time) {
+			pbs = append(pbs, b)
+		}
+	}
+	m.pairBuckets = pbs
+	var tbs []*models.TokenBucket
+	for _, b := range m.tokenBuckets {
+		if !in(b.Time) {
+			tbs = append(tbs, b)
+		}
+	}
+	m.tokenBuckets = tbs
+	var totals []*models.TotalBucket
+	for _, b := range m.totalBuckets {
+		if !in(b.Time) {
+			totals = append(totals, b)
+		}
+	}
+	m.totalBuckets = totals
+	return nil
+}
+
+func (m *mock) ReplaceRollups(ctx context.Context, resolution time.Duration, start time.Time, pbs []*models.PairBucket, tbs []*models.TokenBucket, totals []*models.TotalBucket) error {
+	r := m.rollup(resolution)
+	r.DeleteBuckets(ctx, start, start.Add(time.Second))
+	for _, b := range pbs {
+		r.SavePairBucket(ctx, b)
+	}
+	for _, b := range tbs {
+		r.SaveTokenBucket(ctx, b)
+	}
+	for _, b := range totals {
+		r.SaveTotalBucket(ctx, b)
+	}
+	return nil
+}
+
+func (m *mock) ReplaceSwaps(ctx context.Context, pair string, from, to time.Time, swaps []*models.Swap) error {
+	var kept []*models.Swap
+	for _, s := range m.swaps {
+		if s.Address != pair || s.Time.Before(from) || !s.Time.Before(to) {
+			kept = append(kept, s)
+		}
+	}
+	for _, s := range swaps {
+		s.PreSave()
+	}
+	m.swaps = append(kept, swaps...)
+	return nil
+}
+
+func (m *mock) ReplaceLiquidityEvents(ctx context.Context, pair string, from, to time.Time, events []*models.LiquidityEvent) error {
+	var kept []*models.LiquidityEvent
+	for _, e := range m.liquidity {
+		if e.Address != pair || e.Time.Before(from) || !e.Time.Before(to) {
+			kept = append(kept, e)
+		}
+	}
+	for _, e := range events {
+		e.PreSave()
+	}
+	m.liquidity = append(kept, events...)
+	return nil
+}
+
+func (m *mock) GetLastCheck(ctx context.Context) (*models.LastCheck, error) {
+	lc := *m.lastCheck
+	return &lc, nil
+}
+
+func (m *mock) SaveLastCheck(ctx context.Context, lc *models.LastCheck) error {
+	cp := *lc
+	m.lastCheck = &cp
+	return nil
+}
+
+func (m *mock) GetPairChecks(ctx context.Context) (map[string]*models.PairCheck, error) {
+	pcs := make(map[string]*models.PairCheck, len(m.pairChecks))
+	for k, v := range m.pairChecks {
+		pc := *v
+		pcs[k] = &pc
+	}
+	return pcs, nil
+}
+
+func (m *mock) SavePairCheck(ctx context.Context, pc *models.PairCheck) error {
+	cp := *pc
+	m.pairChecks[pc.Address] = &cp
+	return nil
+}
@@ -0,0 +1,130 @@
+package backend
+
+import (
+	"sort"
+	"time"
+
+	"github.com/goswap/stats-api/models"
+)
+
+const (
+	Day  = 24 * time.Hour
+	Week = 7 * Day
+)
+
+// Resolutions are the bucket sizes that get stored, finest first. Hourly buckets are written by the
+// collector as it goes and each coarser one is rolled up from the one before it, so reading a long
+// range at a coarse time_frame doesn't have to sum up every hour.
+var Resolutions = []time.Duration{time.Hour, Day, Week}
+
+// RollupStart returns the start of the bucket at resolution that t is in. Days start at midnight
+// UTC and weeks on Monday.
+func RollupStart(t time.Time, resolution time.Duration) time.Time {
+	t = t.UTC()
+	if resolution == Week {
+		// the unix epoch was a Thursday
+		return t.Add(3 * Day).Truncate(Week).Add(-3 * Day)
+	}
+	return t.Truncate(resolution)
+}
+
+// resolutionFor returns the coarsest stored resolution that interval is a multiple of and that the
+// hours read between from and to line up with, so rolling up into interval reads as few buckets as
+// possible. Ranges that start or end partway through a day (or week) are read hourly, or a window
+// like the last 24h would only get the buckets of the days it starts in.
+func resolutionFor(from, to time.Time, interval time.Duration) time.Duration {
+	f, t, _ := window(from, to, interval)
+	// the hourly buckets read are the ones after from and before to
+	first := time.Unix(f, 0).Truncate(time.Hour).Add(time.Hour)
+	end := time.Unix(t-1, 0).Truncate(time.Hour).Add(time.Hour)
+	for i := len(Resolutions) - 1; i > 0; i-- {
+		res := Resolutions[i]
+		if interval < res || interval%res != 0 {
+			continue
+		}
+		if RollupStart(first, res).Equal(first) && RollupStart(end, res).Equal(end) {
+			return res
+		}
+	}
+	return Resolutions[0]
+}
+
+// rollupCollection returns the name of the collection (or table) holding buckets from collection
+// at resolution, hourly ones keep the plain name
+func rollupCollection(collection string, resolution time.Duration) string {
+	switch resolution {
+	case Day:
+		return collection + "_day"
+	case Week:
+		return collection + "_week"
+	}
+	return collection
+}
+
+// MergePairBuckets rolls up the buckets into one per pair per window, windowStart returns the start of
+// the window a bucket's time is in. Flows are added up, candles are combined and prices and liquidity
+// are taken from the latest bucket. The given buckets aren't changed.
+func MergePairBuckets(pbs []*models.PairBucket, windowStart func(time.Time) time.Time) []*models.PairBucket {
+	sort.SliceStable(pbs, func(i, j int) bool { return pbs[i].Time.Before(pbs[j].Time) })
+	type k struct {
+		address string
+		start   int64
+	}
+	merged := map[k]*models.PairBucket{}
+	ret := make([]*models.PairBucket, 0)
+	for _, pb := range pbs {
+		start := windowStart(pb.Time)
+		if m := merged[k{pb.Address, start.Unix()}]; m != nil {
+			m.Add(pb)
+			continue
+		}
+		// copy it, backends may hand back what they have stored
+		m := *pb
+		m.Time = start
+		merged[k{pb.Address, start.Unix()}] = &m
+		ret = append(ret, &m)
+	}
+	return ret
+}
+
+// MergeTokenBuckets rolls up the buckets into one per token per window, like MergePairBuckets
+func MergeTokenBuckets(tbs []*models.TokenBucket, windowStart func(time.Time) time.Time) []*models.TokenBucket {
+	sort.SliceStable(tbs, func(i, j int) bool { return tbs[i].Time.Before(tbs[j].Time) })
+	type k struct {
+		address string
+		start   int64
+	}
+	merged := map[k]*models.TokenBucket{}
+	ret := make([]*models.TokenBucket, 0)
+	for _, tb := range tbs {
+		start := windowStart(tb.Time)
+		if m := merged[k{tb.Address, start.Unix()}]; m != nil {
+			m.Add(tb)
+			continue
+		}
+		m := *tb
+		m.Time = start
+		merged[k{tb.Address, start.Unix()}] = &m
+		ret = append(ret, &m)
+	}
+	return ret
+}
+
+// MergeTotals rolls up the totals into one per window, volume is summed and liquidity is the latest
+func MergeTotals(totals []*models.TotalBucket, windowStart func(time.Time) time.Time) []*models.TotalBucket {
+	sort.SliceStable(totals, func(i, j int) bool { return totals[i].Time.Before(totals[j].Time) })
+	ret := make([]*models.TotalBucket, 0)
+	var m *models.TotalBucket
+	for _, t := range totals {
+		start := windowStart(t.Time)
+		if m != nil && m.Time.Equal(start) {
+			m.Add(t)
+			continue
+		}
+		c := *t
+		c.Time = start
+		m = &c
+		ret = append(ret, m)
+	}
+	return ret
+}
@@ -0,0 +1,1082 @@
+package backend
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/goswap/stats-api/models"
+	"github.com/treeder/gotils"
+
+	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// SQL drivers supported by NewSQL
+const (
+	DriverSQLite   = "sqlite3"
+	DriverPostgres = "postgres"
+)
+
+// SQLBackend stores everything in a SQL database, either SQLite for running locally or
+// Postgres. Times are stored as unix seconds and the table names match the firestore
+// collections. Unlike FirestoreBackend, rolling buckets up into the requested interval
+// is done in the database with GROUP BY on Postgres, where NUMERIC adds up exactly. SQLite
+// keeps decimals as TEXT and would add them up as floats, so there the rows are rolled up
+// in Go instead.
+type SQLBackend struct {
+	db     *sql.DB
+	driver string
+	// groupBy is whether buckets are rolled up in the database
+	groupBy bool
+}
+
+// NewSQL opens the database and creates the tables if they don't exist yet
+func NewSQL(ctx context.Context, driver, dsn string) (*SQLBackend, error) {
+	if driver != DriverSQLite && driver != DriverPostgres {
+		return nil, gotils.C(ctx).Errorf("unsupported sql driver %q", driver)
+	}
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		return nil, gotils.C(ctx).Errorf("error opening db: %v", err)
+	}
+	if driver == DriverSQLite {
+		// sqlite doesn't like concurrent writers
+		db.SetMaxOpenConns(1)
+	}
+	s := &SQLBackend{db: db, driver: driver, groupBy: driver == DriverPostgres}
+	err = s.migrate(ctx)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// Close closes the database
+func (s *SQLBackend) Close() error {
+	return s.db.Close()
+}
+
+var placeholderRe = regexp.MustCompile(`\$(\d+)`)
+
+// rebind turns the postgres style $1 placeholders the queries are written with into ?1 for sqlite,
+// which numbers $1 style ones by the order they show up in rather than the number
+func (s *SQLBackend) rebind(q string) string {
+	if s.driver == DriverSQLite {
+		return placeholderRe.ReplaceAllString(q, "?$1")
+	}
+	return q
+}
+
+// numType is the column type for decimals. sqlite would turn NUMERIC into floats, so we keep
+// the exact string there and add them up in Go.
+func (s *SQLBackend) numType() string {
+	if s.driver == DriverPostgres {
+		return "NUMERIC"
+	}
+	return "TEXT"
+}
+
+// sum sums a decimal column, only exact for NUMERIC ones
+func sum(cols ...string) string {
+	sums := make([]string, len(cols))
+	for i, col := range cols {
+		sums[i] = fmt.Sprintf("SUM(%v) AS %v", col, col)
+	}
+	return strings.Join(sums, ", ")
+}
+
+// weighted averages a decimal column, weighted by an integer column
+func weighted(col, weight string) string {
+	return fmt.Sprintf("COALESCE(SUM(%v * %v) / NULLIF(SUM(%v), 0), 0) AS %v", col, weight, weight, col)
+}
+
+// candle aggregates the high and low columns of a decimal candle over a window and finds the
+// open_time and close_time to join the open and close from. Zero candles are skipped so a window
+// opens and closes with the first and last prices in it.
+func candle(open, high, low string) string {
+	hasPrice := open + " > 0"
+	return fmt.Sprintf("MIN(CASE WHEN %v THEN time END) AS open_time, MAX(CASE WHEN %v THEN time END) AS close_time, "+
+		"COALESCE(MAX(%v), 0) AS %v, COALESCE(MIN(CASE WHEN %v THEN %v END), 0) AS %v",
+		hasPrice, hasPrice, high, high, hasPrice, low, low)
+}
+
+// column is a column added to a table after it was first created
+type column struct {
+	table, name, def string
+}
+
+// addColumns adds any of the columns that the table doesn't have yet, so databases created by
+// older versions get them too
+func (s *SQLBackend) addColumns(ctx context.Context, cols []column) error {
+	for _, c := range cols {
+		q := fmt.Sprintf("ALTER TABLE %v ADD COLUMN IF NOT EXISTS %v %v", c.table, c.name, c.def)
+		if s.driver == DriverSQLite {
+			// no IF NOT EXISTS in sqlite, check first
+			var n int
+			err := s.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM pragma_table_info(?1) WHERE name = ?2", c.table, c.name).Scan(&n)
+			if err != nil {
+				return gotils.C(ctx).Errorf("error checking columns: %v", err)
+			}
+			if n > 0 {
+				continue
+			}
+			q = fmt.Sprintf("ALTER TABLE %v ADD COLUMN %v %v", c.table, c.name, c.def)
+		}
+		_, err := s.db.ExecContext(ctx, q)
+		if err != nil {
+			return gotils.C(ctx).Errorf("error adding column %v to %v: %v", c.name, c.table, err)
+		}
+	}
+	return nil
+}
+
+func (s *SQLBackend) migrate(ctx context.Context) error {
+	num := s.numType()
+	stmts := []string{
+		`CREATE TABLE IF NOT EXISTS ` + CollectionPairs + ` (
+			address TEXT PRIMARY KEY,
+			idx INTEGER NOT NULL,
+			pair TEXT NOT NULL,
+			token0 TEXT NOT NULL,
+			token1 TEXT NOT NULL,
+			created_block BIGINT NOT NULL,
+			created_at BIGINT NOT NULL,
+			created_tx_hash TEXT NOT NULL
+		)`,
+		`CREATE TABLE IF NOT EXISTS ` + CollectionTokens + ` (
+			address TEXT PRIMARY KEY,
+			name TEXT NOT NULL,
+			symbol TEXT NOT NULL,
+			decimals INTEGER NOT NULL,
+			total_supply ` + num + ` NOT NULL,
+			cmc_price ` + num + ` NOT NULL
+		)`,
+		`CREATE TABLE IF NOT EXISTS ` + CollectionTimestamps + ` (
+			id TEXT PRIMARY KEY,
+			data TEXT NOT NULL
+		)`,
+		`CREATE TABLE IF NOT EXISTS ` + CollectionPairChecks + ` (
+			address TEXT PRIMARY KEY,
+			last_check_at BIGINT NOT NULL,
+			last_block_number BIGINT NOT NULL
+		)`,
+		// a swap's from and to are stored as sender and recipient, from and to are keywords
+		`CREATE TABLE IF NOT EXISTS ` + CollectionSwaps + ` (
+			tx_hash TEXT NOT NULL,
+			log_index INTEGER NOT NULL,
+			address TEXT NOT NULL,
+			pair TEXT NOT NULL,
+			token0 TEXT NOT NULL,
+			token1 TEXT NOT NULL,
+			block_number BIGINT NOT NULL,
+			time BIGINT NOT NULL,
+			sender TEXT NOT NULL,
+			recipient TEXT NOT NULL,
+			tx_from TEXT NOT NULL,
+			amount0_in ` + num + ` NOT NULL,
+			amount1_in ` + num + ` NOT NULL,
+			amount0_out ` + num + ` NOT NULL,
+			amount1_out ` + num + ` NOT NULL,
+			value_usd ` + num + ` NOT NULL,
+			PRIMARY KEY (tx_hash, log_index)
+		)`,
+		`CREATE INDEX IF NOT EXISTS ` + CollectionSwaps + `_block ON ` + CollectionSwaps + ` (block_number, log_index)`,
+		`CREATE INDEX IF NOT EXISTS ` + CollectionSwaps + `_address ON ` + CollectionSwaps + ` (address, time)`,
+		`CREATE INDEX IF NOT EXISTS ` + CollectionSwaps + `_token0 ON ` + CollectionSwaps + ` (token0)`,
+		`CREATE INDEX IF NOT EXISTS ` + CollectionSwaps + `_token1 ON ` + CollectionSwaps + ` (token1)`,
+		`CREATE INDEX IF NOT EXISTS ` + CollectionSwaps + `_tx_from ON ` + CollectionSwaps + ` (tx_from)`,
+		`CREATE INDEX IF NOT EXISTS ` + CollectionSwaps + `_recipient ON ` + CollectionSwaps + ` (recipient)`,
+		`CREATE TABLE IF NOT EXISTS ` + CollectionLiquidityEvents + ` (
+			tx_hash TEXT NOT NULL,
+			log_index INTEGER NOT NULL,
+			type TEXT NOT NULL,
+			address TEXT NOT NULL,
+			pair TEXT NOT NULL,
+			token0 TEXT NOT NULL,
+			token1 TEXT NOT NULL,
+			block_number BIGINT NOT NULL,
+			time BIGINT NOT NULL,
+			sender TEXT NOT NULL,
+			recipient TEXT NOT NULL,
+			tx_from TEXT NOT NULL,
+			amount0 ` + num + ` NOT NULL,
+			amount1 ` + num + ` NOT NULL,
+			value_usd ` + num + ` NOT NULL,
+			liquidity ` + num + ` NOT NULL,
+			PRIMARY KEY (tx_hash, log_index)
+		)`,
+		`CREATE INDEX IF NOT EXISTS ` + CollectionLiquidityEvents + `_block ON ` + CollectionLiquidityEvents + ` (block_number, log_index)`,
+		`CREATE INDEX IF NOT EXISTS ` + CollectionLiquidityEvents + `_address ON ` + CollectionLiquidityEvents + ` (address, time)`,
+		`CREATE INDEX IF NOT EXISTS ` + CollectionLiquidityEvents + `_tx_from ON ` + CollectionLiquidityEvents + ` (tx_from)`,
+		`CREATE INDEX IF NOT EXISTS ` + CollectionLiquidityEvents + `_recipient ON ` + CollectionLiquidityEvents + ` (recipient)`,
+	}
+	var cols []column
+	// the same bucket tables for every resolution
+	for _, res := range Resolutions {
+		pairBuckets := rollupCollection(CollectionPairBuckets, res)
+		tokenBuckets := rollupCollection(CollectionTokenBuckets, res)
+		totals := rollupCollection(CollectionTotals, res)
+		stmts = append(stmts,
+			`CREATE TABLE IF NOT EXISTS `+pairBuckets+` (
+				address TEXT NOT NULL,
+				time BIGINT NOT NULL,
+				pair TEXT NOT NULL,
+				amount0_in `+num+` NOT NULL,
+				amount1_in `+num+` NOT NULL,
+				amount0_out `+num+` NOT NULL,
+				amount1_out `+num+` NOT NULL,
+				price0_usd `+num+` NOT NULL,
+				price1_usd `+num+` NOT NULL,
+				volume_usd `+num+` NOT NULL,
+				liquidity_added0 `+num+` NOT NULL,
+				liquidity_added1 `+num+` NOT NULL,
+				liquidity_added_usd `+num+` NOT NULL,
+				mint_count INTEGER NOT NULL,
+				liquidity_removed0 `+num+` NOT NULL,
+				liquidity_removed1 `+num+` NOT NULL,
+				liquidity_removed_usd `+num+` NOT NULL,
+				total_supply `+num+` NOT NULL,
+				reserve0 `+num+` NOT NULL,
+				reserve1 `+num+` NOT NULL,
+				PRIMARY KEY (address, time)
+			)`,
+			`CREATE INDEX IF NOT EXISTS `+pairBuckets+`_time ON `+pairBuckets+` (time)`,
+			`CREATE TABLE IF NOT EXISTS `+tokenBuckets+` (
+				address TEXT NOT NULL,
+				time BIGINT NOT NULL,
+				symbol TEXT NOT NULL,
+				amount_in `+num+` NOT NULL,
+				amount_out `+num+` NOT NULL,
+				price_usd `+num+` NOT NULL,
+				volume_usd `+num+` NOT NULL,
+				liquidity_added `+num+` NOT NULL,
+				liquidity_added_usd `+num+` NOT NULL,
+				mint_count INTEGER NOT NULL,
+				liquidity_removed `+num+` NOT NULL,
+				liquidity_removed_usd `+num+` NOT NULL,
+				reserve `+num+` NOT NULL,
+				PRIMARY KEY (address, time)
+			)`,
+			`CREATE INDEX IF NOT EXISTS `+tokenBuckets+`_time ON `+tokenBuckets+` (time)`,
+			`CREATE TABLE IF NOT EXISTS `+totals+` (
+				time BIGINT PRIMARY KEY,
+				volume_usd `+num+` NOT NULL,
+				liquidity_usd `+num+` NOT NULL
+			)`,
+		)
+		cols = append(cols,
+			column{pairBuckets, "twap0", num + " NOT NULL DEFAULT 0"},
+			column{pairBuckets, "twap1", num + " NOT NULL DEFAULT 0"},
+			column{pairBuckets, "twap_seconds", "BIGINT NOT NULL DEFAULT 0"},
+			column{pairBuckets, "price0_cumulative", num + " NOT NULL DEFAULT 0"},
+			column{pairBuckets, "price1_cumulative", num + " NOT NULL DEFAULT 0"},
+			column{pairBuckets, "cumulative_at", "BIGINT NOT NULL DEFAULT 0"},
+			column{pairBuckets, "open0", num + " NOT NULL DEFAULT 0"},
+			column{pairBuckets, "high0", num + " NOT NULL DEFAULT 0"},
+			column{pairBuckets, "low0", num + " NOT NULL DEFAULT 0"},
+			column{pairBuckets, "close0", num + " NOT NULL DEFAULT 0"},
+			column{tokenBuckets, "open_usd", num + " NOT NULL DEFAULT 0"},
+			column{tokenBuckets, "high_usd", num + " NOT NULL DEFAULT 0"},
+			column{tokenBuckets, "low_usd", num + " NOT NULL DEFAULT 0"},
+			column{tokenBuckets, "close_usd", num + " NOT NULL DEFAULT 0"},
+		)
+		// traders are the distinct accounts that swapped, comma separated
+		for _, table := range []string{pairBuckets, tokenBuckets, totals} {
+			cols = append(cols,
+				column{table, "swap_count", "INTEGER NOT NULL DEFAULT 0"},
+				column{table, "traders", "TEXT NOT NULL DEFAULT ''"},
+				column{table, "fees_usd", num + " NOT NULL DEFAULT 0"},
+			)
+		}
+	}
+	for _, stmt := range stmts {
+		_, err := s.db.ExecContext(ctx, stmt)
+		if err != nil {
+			return gotils.C(ctx).Errorf("error creating tables: %v", err)
+		}
+	}
+	return s.addColumns(ctx, cols)
+}
+
+// upsert builds an insert that overwrites the row if the key already exists, this works the same
+// in sqlite and postgres
+func upsert(table string, key []string, cols []string) string {
+	params := make([]string, len(cols))
+	var sets []string
+	for i, c := range cols {
+		params[i] = fmt.Sprintf("$%v", i+1)
+		isKey := false
+		for _, k := range key {
+			if k == c {
+				isKey = true
+			}
+		}
+		if !isKey {
+			sets = append(sets, fmt.Sprintf("%v = excluded.%v", c, c))
+		}
+	}
+	return fmt.Sprintf("INSERT INTO %v (%v) VALUES (%v) ON CONFLICT (%v) DO UPDATE SET %v",
+		table, strings.Join(cols, ", "), strings.Join(params, ", "), strings.Join(key, ", "), strings.Join(sets, ", "))
+}
+
+// window returns the bounds and interval in seconds to query buckets with. Buckets are grouped
+// back from to, like FirestoreBackend does, so the last window always ends at to.
+func window(from, to time.Time, interval time.Duration) (int64, int64, int64) {
+	if to.IsZero() {
+		to = time.Now()
+	}
+	secs := int64(interval / time.Second)
+	if secs < 1 {
+		secs = 1 // no rolling up
+	}
+	return from.Unix(), to.Unix(), secs
+}
+
+const pairCols = "address, idx, pair, token0, token1, created_block, created_at, created_tx_hash"
+
+func scanPair(ctx context.Context, rows *sql.Rows) (*models.Pair, error) {
+	p := new(models.Pair)
+	var createdAt int64
+	err := rows.Scan(&p.AddressHex, &p.Index, &p.Pair, &p.Token0Address, &p.Token1Address, &p.CreatedBlock, &createdAt, &p.CreatedTxHash)
+	if err != nil {
+		return nil, gotils.C(ctx).Errorf("%v", err)
+	}
+	if createdAt != 0 {
+		p.CreatedAt = time.Unix(createdAt, 0)
+	}
+	p.AfterLoad(ctx)
+	return p, nil
+}
+
+// GetPairs returns all available pairs
+func (s *SQLBackend) GetPairs(ctx context.Context) ([]*models.Pair, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT "+pairCols+" FROM "+CollectionPairs+" ORDER BY idx")
+	if err != nil {
+		return nil, gotils.C(ctx).Errorf("error getting data: %v", err)
+	}
+	defer rows.Close()
+
+	pairs := make([]*models.Pair, 0)
+	for rows.Next() {
+		p, err := scanPair(ctx, rows)
+		if err != nil {
+			return nil, err
+		}
+		pairs = append(pairs, p)
+	}
+	return pairs, rows.Err()
+}
+
+// GetPair returns pair by address
+func (s *SQLBackend) GetPair(ctx context.Context, address string) (*models.Pair, error) {
+	rows, err := s.db.QueryContext(ctx, s.rebind("SELECT "+pairCols+" FROM "+CollectionPairs+" WHERE address = $1"), address)
+	if err != nil {
+		return nil, gotils.C(ctx).Errorf("error getting data: %v", err)
+	}
+	defer rows.Close()
+	if rows.Next() {
+		return scanPair(ctx, rows)
+	}
+	return nil, gotils.ErrNotFound
+}
+
+const tokenCols = "address, name, symbol, decimals, total_supply, cmc_price"
+
+func scanToken(ctx context.Context, rows *sql.Rows) (*models.Token, error) {
+	t := new(models.Token)
+	err := rows.Scan(&t.AddressHex, &t.Name, &t.Symbol, &t.Decimals, &t.TotalSupplyS, &t.CMCPriceS)
+	if err != nil {
+		return nil, gotils.C(ctx).Errorf("%v", err)
+	}
+	t.AfterLoad(ctx)
+	return t, nil
+}
+
+// GetTokens returns all the available tokens
+func (s *SQLBackend) GetTokens(ctx context.Context) ([]*models.Token, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT "+tokenCols+" FROM "+CollectionTokens)
+	if err != nil {
+		return nil, gotils.C(ctx).Errorf("error getting data: %v", err)
+	}
+	defer rows.Close()
+
+	tokens := make([]*models.Token, 0)
+	for rows.Next() {
+		t, err := scanToken(ctx, rows)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, t)
+	}
+	return tokens, rows.Err()
+}
+
+// GetToken returns token by address
+func (s *SQLBackend) GetToken(ctx context.Context, address string) (*models.Token, error) {
+	rows, err := s.db.QueryContext(ctx, s.rebind("SELECT "+tokenCols+" FROM "+CollectionTokens+" WHERE address = $1"), address)
+	if err != nil {
+		return nil, gotils.C(ctx).Errorf("error getting data: %v", err)
+	}
+	defer rows.Close()
+	if rows.Next() {
+		return scanToken(ctx, rows)
+	}
+	return nil, gotils.ErrNotFound
+}
+
+// group returns which window of secs, counting back from to, a bucket at bt is in, the same windows
+// the GROUP BY queries use
+func group(to, secs, bt int64) int64 {
+	return (to - 1 - bt) / secs
+}
+
+const totalCols = "time, volume_usd, fees_usd, liquidity_usd, swap_count, traders"
+
+// GetTotals returns the total volume and liquidity over all tokens in the
+// given time window at the given duration (eg per minute, per day, etc).
+func (s *SQLBackend) GetTotals(ctx context.Context, from, to time.Time, interval time.Duration) ([]*models.TotalBucket, error) {
+	f, t, secs := window(from, to, interval)
+	table := rollupCollection(CollectionTotals, resolutionFor(from, to, interval))
+	args := []interface{}{f, t}
+	q := "SELECT " + totalCols + " FROM " + table + " WHERE time > $1 AND time < $2 ORDER BY time"
+	if s.groupBy {
+		// volume is summed, liquidity is the last data point in the window
+		q = `SELECT g.time, g.volume_usd, g.fees_usd, l.liquidity_usd, g.swap_count, g.traders FROM (
+				SELECT MAX(time) AS time, ` + sum("volume_usd", "fees_usd", "swap_count") + `,
+					STRING_AGG(traders, ',') AS traders
+				FROM ` + table + `
+				WHERE time > $1 AND time < $2
+				GROUP BY ($2 - 1 - time) / $3
+			) g JOIN ` + table + ` l ON l.time = g.time
+			ORDER BY g.time`
+		args = append(args, secs)
+	}
+	rows, err := s.db.QueryContext(ctx, s.rebind(q), args...)
+	if err != nil {
+		return nil, gotils.C(ctx).Errorf("error getting data: %v", err)
+	}
+	defer rows.Close()
+
+	// rows that weren't grouped already are rolled up here the same way
+	ret := make([]*models.TotalBucket, 0)
+	var m *models.TotalBucket
+	var mg int64
+	for rows.Next() {
+		b := new(models.TotalBucket)
+		var bt int64
+		var traders string
+		err = rows.Scan(&bt, &b.VolumeUSDS, &b.FeesUSDS, &b.LiquidityUSDS, &b.SwapCount, &traders)
+		if err != nil {
+			return nil, gotils.C(ctx).Errorf("%v", err)
+		}
+		b.Time = time.Unix(bt, 0)
+		b.AddTraders(strings.Split(traders, ",")...)
+		b.AfterLoad(ctx)
+		g := group(t, secs, bt)
+		if m != nil && g == mg {
+			m.Add(b)
+			m.Time = b.Time
+			continue
+		}
+		m, mg = b, g
+		ret = append(ret, m)
+	}
+	return ret, rows.Err()
+}
+
+const pairBucketCols = "address, time, pair, " +
+	"amount0_in, amount1_in, amount0_out, amount1_out, price0_usd, price1_usd, volume_usd, fees_usd, " +
+	"swap_count, traders, " +
+	"liquidity_added0, liquidity_added1, liquidity_added_usd, mint_count, " +
+	"liquidity_removed0, liquidity_removed1, liquidity_removed_usd, " +
+	"total_supply, reserve0, reserve1, " +
+	"twap0, twap1, twap_seconds, price0_cumulative, price1_cumulative, cumulative_at, " +
+	"open0, high0, low0, close0"
+
+// GetPairBuckets returns the buckets for the pair, or all pairs if pair is empty, rolled up
+// into the given interval
+func (s *SQLBackend) GetPairBuckets(ctx context.Context, pair string, from, to time.Time, interval time.Duration) ([]*models.PairBucket, error) {
+	f, t, secs := window(from, to, interval)
+	table := rollupCollection(CollectionPairBuckets, resolutionFor(from, to, interval))
+	args := []interface{}{f, t}
+	where := "time > $1 AND time < $2"
+	if pair != "" {
+		where += " AND address = $3"
+		args = append(args, pair)
+	}
+	q := "SELECT " + pairBucketCols + " FROM " + table + " WHERE " + where + " ORDER BY address, time"
+	if s.groupBy {
+		args = append(args, secs)
+		// flows are summed, prices and liquidity are the last data point in the window and candles open and
+		// close with the first and last ones that have prices
+		q = `SELECT g.address, g.time, l.pair,
+				g.amount0_in, g.amount1_in, g.amount0_out, g.amount1_out, l.price0_usd, l.price1_usd, g.volume_usd, g.fees_usd,
+				g.swap_count, g.traders,
+				g.liquidity_added0, g.liquidity_added1, g.liquidity_added_usd, g.mint_count,
+				g.liquidity_removed0, g.liquidity_removed1, g.liquidity_removed_usd,
+				l.total_supply, l.reserve0, l.reserve1,
+				g.twap0, g.twap1, g.twap_seconds, l.price0_cumulative, l.price1_cumulative, l.cumulative_at,
+				COALESCE(o.open0, 0), g.high0, g.low0, COALESCE(c.close0, 0)
+			FROM (
+				SELECT address, MAX(time) AS time,
+					` + sum("amount0_in", "amount1_in", "amount0_out", "amount1_out", "volume_usd", "fees_usd", "swap_count") + `,
+					STRING_AGG(traders, ',') AS traders,
+					` + sum("liquidity_added0", "liquidity_added1", "liquidity_added_usd", "mint_count") + `,
+					` + sum("liquidity_removed0", "liquidity_removed1", "liquidity_removed_usd") + `,
+					` + weighted("twap0", "twap_seconds") + `, ` + weighted("twap1", "twap_seconds") + `,
+					SUM(twap_seconds) AS twap_seconds,
+					` + candle("open0", "high0", "low0") + `
+				FROM ` + table + `
+				WHERE ` + where + `
+				GROUP BY address, ($2 - 1 - time) / $` + fmt.Sprint(len(args)) + `
+			) g JOIN ` + table + ` l ON l.address = g.address AND l.time = g.time
+			LEFT JOIN ` + table + ` o ON o.address = g.address AND o.time = g.open_time
+			LEFT JOIN ` + table + ` c ON c.address = g.address AND c.time = g.close_time
+			ORDER BY g.address, g.time`
+	}
+	rows, err := s.db.QueryContext(ctx, s.rebind(q), args...)
+	if err != nil {
+		return nil, gotils.C(ctx).Errorf("error getting data: %v", err)
+	}
+	defer rows.Close()
+
+	// rows that weren't grouped already are rolled up here the same way, see PairBucket.Add
+	pairs := make([]*models.PairBucket, 0)
+	var m *models.PairBucket
+	var mg int64
+	for rows.Next() {
+		p := new(models.PairBucket)
+		var bt, ct int64
+		var traders string
+		err = rows.Scan(&p.Address, &bt, &p.Pair,
+			&p.Amount0InS, &p.Amount1InS, &p.Amount0OutS, &p.Amount1OutS, &p.Price0USDS, &p.Price1USDS, &p.VolumeUSDS, &p.FeesUSDS,
+			&p.SwapCount, &traders,
+			&p.LiquidityAdded0S, &p.LiquidityAdded1S, &p.LiquidityAddedUSDS, &p.MintCount,
+			&p.LiquidityRemoved0S, &p.LiquidityRemoved1S, &p.LiquidityRemovedUSDS,
+			&p.TotalSupplyS, &p.Reserve0S, &p.Reserve1S,
+			&p.TWAP0S, &p.TWAP1S, &p.TWAPSeconds, &p.Price0CumulativeS, &p.Price1CumulativeS, &ct,
+			&p.Open0S, &p.High0S, &p.Low0S, &p.Close0S)
+		if err != nil {
+			return nil, gotils.C(ctx).Errorf("%v", err)
+		}
+		p.Time = time.Unix(bt, 0)
+		p.AddTraders(strings.Split(traders, ",")...)
+		if ct != 0 {
+			p.CumulativeAt = time.Unix(ct, 0)
+		}
+		p.AfterLoad(ctx)
+		g := group(t, secs, bt)
+		if m != nil && m.Address == p.Address && g == mg {
+			m.Add(p)
+			m.Time = p.Time
+			continue
+		}
+		m, mg = p, g
+		pairs = append(pairs, m)
+	}
+	return pairs, rows.Err()
+}
+
+const tokenBucketCols = "address, time, symbol, " +
+	"amount_in, amount_out, price_usd, volume_usd, fees_usd, " +
+	"swap_count, traders, " +
+	"liquidity_added, liquidity_added_usd, mint_count, " +
+	"liquidity_removed, liquidity_removed_usd, reserve, " +
+	"open_usd, high_usd, low_usd, close_usd"
+
+// GetTokenBuckets returns the buckets for the token, or all tokens if token is empty, rolled up
+// into the given interval
+func (s *SQLBackend) GetTokenBuckets(ctx context.Context, token string, from, to time.Time, interval time.Duration) ([]*models.TokenBucket, error) {
+	f, t, secs := window(from, to, interval)
+	table := rollupCollection(CollectionTokenBuckets, resolutionFor(from, to, interval))
+	args := []interface{}{f, t}
+	where := "time > $1 AND time < $2"
+	if token != "" {
+		where += " AND address = $3"
+		args = append(args, token)
+	}
+	q := "SELECT " + tokenBucketCols + " FROM " + table + " WHERE " + where + " ORDER BY address, time"
+	if s.groupBy {
+		args = append(args, secs)
+		// flows are summed, price and reserve are the last data point in the window and candles are
+		// combined like for pairs
+		q = `SELECT g.address, g.time, l.symbol,
+				g.amount_in, g.amount_out, l.price_usd, g.volume_usd, g.fees_usd,
+				g.swap_count, g.traders,
+				g.liquidity_added, g.liquidity_added_usd, g.mint_count,
+				g.liquidity_removed, g.liquidity_removed_usd, l.reserve,
+				COALESCE(o.open_usd, 0), g.high_usd, g.low_usd, COALESCE(c.close_usd, 0)
+			FROM (
+				SELECT address, MAX(time) AS time,
+					` + sum("amount_in", "amount_out", "volume_usd", "fees_usd", "swap_count") + `,
+					STRING_AGG(traders, ',') AS traders,
+					` + sum("liquidity_added", "liquidity_added_usd", "mint_count", "liquidity_removed", "liquidity_removed_usd") + `,
+					` + candle("open_usd", "high_usd", "low_usd") + `
+				FROM ` + table + `
+				WHERE ` + where + `
+				GROUP BY address, ($2 - 1 - time) / $` + fmt.Sprint(len(args)) + `
+			) g JOIN ` + table + ` l ON l.address = g.address AND l.time = g.time
+			LEFT JOIN ` + table + ` o ON o.address = g.address AND o.time = g.open_time
+			LEFT JOIN ` + table + ` c ON c.address = g.address AND c.time = g.close_time
+			ORDER BY g.address, g.time`
+	}
+	rows, err := s.db.QueryContext(ctx, s.rebind(q), args...)
+	if err != nil {
+		return nil, gotils.C(ctx).Errorf("error getting data: %v", err)
+	}
+	defer rows.Close()
+
+	// rows that weren't grouped already are rolled up here the same way, see TokenBucket.Add
+	tokens := make([]*models.TokenBucket, 0)
+	var m *models.TokenBucket
+	var mg int64
+	for rows.Next() {
+		tb := new(models.TokenBucket)
+		var bt int64
+		var traders string
+		err = rows.Scan(&tb.Address, &bt, &tb.Symbol,
+			&tb.AmountInS, &tb.AmountOutS, &tb.PriceUSDS, &tb.VolumeUSDS, &tb.FeesUSDS,
+			&tb.SwapCount, &traders,
+			&tb.LiquidityAddedS, &tb.LiquidityAddedUSDS, &tb.MintCount,
+			&tb.LiquidityRemovedS, &tb.LiquidityRemovedUSDS, &tb.ReserveS,
+			&tb.OpenUSDS, &tb.HighUSDS, &tb.LowUSDS, &tb.CloseUSDS)
+		if err != nil {
+			return nil, gotils.C(ctx).Errorf("%v", err)
+		}
+		tb.Time = time.Unix(bt, 0)
+		tb.AddTraders(strings.Split(traders, ",")...)
+		tb.AfterLoad(ctx)
+		g := group(t, secs, bt)
+		if m != nil && m.Address == tb.Address && g == mg {
+			m.Add(tb)
+			m.Time = tb.Time
+			continue
+		}
+		m, mg = tb, g
+		tokens = append(tokens, m)
+	}
+	return tokens, rows.Err()
+}
+
+const swapCols = "tx_hash, log_index, address, pair, token0, token1, block_number, time, sender, recipient, tx_from, amount0_in, amount1_in, amount0_out, amount1_out, value_usd"
+
+// GetSwaps returns the swaps that match the filter, newest first
+func (s *SQLBackend) GetSwaps(ctx context.Context, f *SwapFilter) ([]*models.Swap, *EventCursor, error) {
+	var where []string
+	var args []interface{}
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%v", len(args))
+	}
+	if f.Pair != "" {
+		where = append(where, "address = "+arg(f.Pair))
+	}
+	if f.Token != "" {
+		p := arg(f.Token)
+		where = append(where, "(token0 = "+p+" OR token1 = "+p+")")
+	}
+	if f.Wallet != "" {
+		p := arg(f.Wallet)
+		where = append(where, "(tx_from = "+p+" OR recipient = "+p+")")
+	}
+	if !f.From.IsZero() {
+		where = append(where, "time >= "+arg(f.From.Unix()))
+	}
+	if !f.To.IsZero() {
+		where = append(where, "time < "+arg(f.To.Unix()))
+	}
+	// sqlite can only compare the decimal strings as floats, so there min_usd is checked as the
+	// rows come in instead
+	minUSD := f.MinUSD.IsPositive() && s.driver != DriverPostgres
+	if f.MinUSD.IsPositive() && !minUSD {
+		where = append(where, "value_usd >= "+arg(f.MinUSD.String()))
+	}
+	if f.Cursor != nil {
+		b := arg(f.Cursor.BlockNumber)
+		where = append(where, "(block_number < "+b+" OR (block_number = "+b+" AND log_index < "+arg(f.Cursor.LogIndex)+"))")
+	}
+	q := "SELECT " + swapCols + " FROM " + CollectionSwaps
+	if len(where) > 0 {
+		q += " WHERE " + strings.Join(where, " AND ")
+	}
+	q += " ORDER BY block_number DESC, log_index DESC"
+	if f.Limit > 0 && !minUSD {
+		// one more to tell if there's another page
+		q += " LIMIT " + arg(f.Limit+1)
+	}
+	rows, err := s.db.QueryContext(ctx, s.rebind(q), args...)
+	if err != nil {
+		return nil, nil, gotils.C(ctx).Errorf("error getting data: %v", err)
+	}
+	defer rows.Close()
+
+	swaps := make([]*models.Swap, 0)
+	for (f.Limit <= 0 || len(swaps) <= f.Limit) && rows.Next() {
+		sw := new(models.Swap)
+		var st int64
+		err = rows.Scan(&sw.TxHash, &sw.LogIndex, &sw.Address, &sw.Pair, &sw.Token0, &sw.Token1, &sw.BlockNumber, &st,
+			&sw.From, &sw.To, &sw.TxFrom, &sw.Amount0InS, &sw.Amount1InS, &sw.Amount0OutS, &sw.Amount1OutS, &sw.ValueUSDS)
+		if err != nil {
+			return nil, nil, gotils.C(ctx).Errorf("%v", err)
+		}
+		sw.Time = time.Unix(st, 0)
+		sw.AfterLoad(ctx)
+		if minUSD && sw.ValueUSD.LessThan(f.MinUSD) {
+			continue
+		}
+		swaps = append(swaps, sw)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, nil, gotils.C(ctx).Errorf("%v", err)
+	}
+	swaps, next := f.page(swaps)
+	return swaps, next, nil
+}
+
+const liquidityEventCols = "tx_hash, log_index, type, address, pair, token0, token1, block_number, time, sender, recipient, tx_from, amount0, amount1, value_usd, liquidity"
+
+// GetLiquidityEvents returns the mints and burns that match the filter, newest first
+func (s *SQLBackend) GetLiquidityEvents(ctx context.Context, f *LiquidityEventFilter) ([]*models.LiquidityEvent, *EventCursor, error) {
+	var where []string
+	var args []interface{}
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%v", len(args))
+	}
+	if f.Pair != "" {
+		where = append(where, "address = "+arg(f.Pair))
+	}
+	if f.Wallet != "" {
+		p := arg(f.Wallet)
+		where = append(where, "(tx_from = "+p+" OR recipient = "+p+")")
+	}
+	if f.Type != "" {
+		where = append(where, "type = "+arg(f.Type))
+	}
+	if !f.From.IsZero() {
+		where = append(where, "time >= "+arg(f.From.Unix()))
+	}
+	if !f.To.IsZero() {
+		where = append(where, "time < "+arg(f.To.Unix()))
+	}
+	if f.Cursor != nil {
+		b := arg(f.Cursor.BlockNumber)
+		where = append(where, "(block_number < "+b+" OR (block_number = "+b+" AND log_index < "+arg(f.Cursor.LogIndex)+"))")
+	}
+	q := "SELECT " + liquidityEventCols + " FROM " + CollectionLiquidityEvents
+	if len(where) > 0 {
+		q += " WHERE " + strings.Join(where, " AND ")
+	}
+	q += " ORDER BY block_number DESC, log_index DESC"
+	if f.Limit > 0 {
+		// one more to tell if there's another page
+		q += " LIMIT " + arg(f.Limit+1)
+	}
+	rows, err := s.db.QueryContext(ctx, s.rebind(q), args...)
+	if err != nil {
+		return nil, nil, gotils.C(ctx).Errorf("error getting data: %v", err)
+	}
+	defer rows.Close()
+
+	events := make([]*models.LiquidityEvent, 0)
+	for rows.Next() {
+		e := new(models.LiquidityEvent)
+		var et int64
+		err = rows.Scan(&e.TxHash, &e.LogIndex, &e.Type, &e.Address, &e.Pair, &e.Token0, &e.Token1, &e.BlockNumber, &et,
+			&e.From, &e.To, &e.TxFrom, &e.Amount0S, &e.Amount1S, &e.ValueUSDS, &e.LiquidityS)
+		if err != nil {
+			return nil, nil, gotils.C(ctx).Errorf("%v", err)
+		}
+		e.Time = time.Unix(et, 0)
+		e.AfterLoad(ctx)
+		events = append(events, e)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, nil, gotils.C(ctx).Errorf("%v", err)
+	}
+	events, next := f.page(events)
+	return events, next, nil
+}
+
+// SavePair stores the pair, keyed by its address
+func (s *SQLBackend) SavePair(ctx context.Context, p *models.Pair) error {
+	p.PreSave()
+	var createdAt int64
+	if !p.CreatedAt.IsZero() {
+		createdAt = p.CreatedAt.Unix()
+	}
+	_, err := s.db.ExecContext(ctx, s.rebind(upsert(CollectionPairs, []string{"address"}, strings.Split(pairCols, ", "))),
+		p.AddressHex, p.Index, p.Pair, p.Token0Address, p.Token1Address, p.CreatedBlock, createdAt, p.CreatedTxHash)
+	if err != nil {
+		return gotils.C(ctx).Errorf("error storing pair: %v", err)
+	}
+	return nil
+}
+
+// SaveToken stores the token, keyed by its address
+func (s *SQLBackend) SaveToken(ctx context.Context, t *models.Token) error {
+	t.PreSave()
+	_, err := s.db.ExecContext(ctx, s.rebind(upsert(CollectionTokens, []string{"address"}, strings.Split(tokenCols, ", "))),
+		t.AddressHex, t.Name, t.Symbol, t.Decimals, t.TotalSupplyS, t.CMCPriceS)
+	if err != nil {
+		return gotils.C(ctx).Errorf("error storing token: %v", err)
+	}
+	return nil
+}
+
+// execer is a *sql.DB or *sql.Tx
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// SavePairBucket stores the bucket, keyed by pair address and time
+func (s *SQLBackend) SavePairBucket(ctx context.Context, b *models.PairBucket) error {
+	return s.savePairBucket(ctx, s.db, CollectionPairBuckets, b)
+}
+
+func (s *SQLBackend) savePairBucket(ctx context.Context, db execer, table string, b *models.PairBucket) error {
+	b.PreSave()
+	var cumulativeAt int64
+	if !b.CumulativeAt.IsZero() {
+		cumulativeAt = b.CumulativeAt.Unix()
+	}
+	_, err := db.ExecContext(ctx, s.rebind(upsert(table, []string{"address", "time"}, strings.Split(pairBucketCols, ", "))),
+		b.Address, b.Time.Unix(), b.Pair,
+		b.Amount0InS, b.Amount1InS, b.Amount0OutS, b.Amount1OutS, b.Price0USDS, b.Price1USDS, b.VolumeUSDS, b.FeesUSDS,
+		b.SwapCount, strings.Join(b.Traders, ","),
+		b.LiquidityAdded0S, b.LiquidityAdded1S, b.LiquidityAddedUSDS, b.MintCount,
+		b.LiquidityRemoved0S, b.LiquidityRemoved1S, b.LiquidityRemovedUSDS,
+		b.TotalSupplyS, b.Reserve0S, b.Reserve1S,
+		b.TWAP0S, b.TWAP1S, b.TWAPSeconds, b.Price0CumulativeS, b.Price1CumulativeS, cumulativeAt,
+		b.Open0S, b.High0S, b.Low0S, b.Close0S)
+	if err != nil {
+		return gotils.C(ctx).Errorf("error writing to db: %v", err)
+	}
+	return nil
+}
+
+// SaveTokenBucket stores the bucket, keyed by token address and time
+func (s *SQLBackend) SaveTokenBucket(ctx context.Context, b *models.TokenBucket) error {
+	return s.saveTokenBucket(ctx, s.db, CollectionTokenBuckets, b)
+}
+
+func (s *SQLBackend) saveTokenBucket(ctx context.Context, db execer, table string, b *models.TokenBucket) error {
+	b.PreSave()
+	_, err := db.ExecContext(ctx, s.rebind(upsert(table, []string{"address", "time"}, strings.Split(tokenBucketCols, ", "))),
+		b.Address, b.Time.Unix(), b.Symbol,
+		b.AmountInS, b.AmountOutS, b.PriceUSDS, b.VolumeUSDS, b.FeesUSDS,
+		b.SwapCount, strings.Join(b.Traders, ","),
+		b.LiquidityAddedS, b.LiquidityAddedUSDS, b.MintCount,
+		b.LiquidityRemovedS, b.LiquidityRemovedUSDS, b.ReserveS,
+		b.OpenUSDS, b.HighUSDS, b.LowUSDS, b.CloseUSDS)
+	if err != nil {
+		return gotils.C(ctx).Errorf("error writing to db: %v", err)
+	}
+	return nil
+}
+
+// SaveTotalBucket stores the bucket, keyed by time
+func (s *SQLBackend) SaveTotalBucket(ctx context.Context, b *models.TotalBucket) error {
+	return s.saveTotalBucket(ctx, s.db, CollectionTotals, b)
+}
+
+func (s *SQLBackend) saveTotalBucket(ctx context.Context, db execer, table string, b *models.TotalBucket) error {
+	b.PreSave()
+	_, err := db.ExecContext(ctx, s.rebind(upsert(table, []string{"time"}, strings.Split(totalCols, ", "))),
+		b.Time.Unix(), b.VolumeUSDS, b.FeesUSDS, b.LiquidityUSDS, b.SwapCount, strings.Join(b.Traders, ","))
+	if err != nil {
+		return gotils.C(ctx).Errorf("error writing to db: %v", err)
+	}
+	return nil
+}
+
+// DeleteBuckets removes all hourly buckets at or after from and before to, so they can be recomputed from scratch
+func (s *SQLBackend) DeleteBuckets(ctx context.Context, from, to time.Time) error {
+	for _, c := range []string{CollectionPairBuckets, CollectionTokenBuckets, CollectionTotals} {
+		q := "DELETE FROM " + c + " WHERE time >= $1"
+		args := []interface{}{from.Unix()}
+		if !to.IsZero() {
+			q += " AND time < $2"
+			args = append(args, to.Unix())
+		}
+		res, err := s.db.ExecContext(ctx, s.rebind(q), args...)
+		if err != nil {
+			return gotils.C(ctx).Errorf("error deleting from %v: %v", c, err)
+		}
+		n, _ := res.RowsAffected()
+		fmt.Printf("deleted %v rows from %v between %v and %v\n", n, c, from, to)
+	}
+	return nil
+}
+
+// ReplaceRollups deletes the buckets at resolution for the period starting at start and stores the
+// given ones in their place, all in one transaction
+func (s *SQLBackend) ReplaceRollups(ctx context.Context, resolution time.Duration, start time.Time, pbs []*models.PairBucket, tbs []*models.TokenBucket, totals []*models.TotalBucket) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return gotils.C(ctx).Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+	for _, c := range []string{CollectionPairBuckets, CollectionTokenBuckets, CollectionTotals} {
+		_, err = tx.ExecContext(ctx, s.rebind("DELETE FROM "+rollupCollection(c, resolution)+" WHERE time = $1"), start.Unix())
+		if err != nil {
+			return gotils.C(ctx).Errorf("error deleting from %v: %v", c, err)
+		}
+	}
+	for _, b := range pbs {
+		err = s.savePairBucket(ctx, tx, rollupCollection(CollectionPairBuckets, resolution), b)
+		if err != nil {
+			return err
+		}
+	}
+	for _, b := range tbs {
+		err = s.saveTokenBucket(ctx, tx, rollupCollection(CollectionTokenBuckets, resolution), b)
+		if err != nil {
+			return err
+		}
+	}
+	for _, b := range totals {
+		err = s.saveTotalBucket(ctx, tx, rollupCollection(CollectionTotals, resolution), b)
+		if err != nil {
+			return err
+		}
+	}
+	err = tx.Commit()
+	if err != nil {
+		return gotils.C(ctx).Errorf("error committing rollups: %v", err)
+	}
+	return nil
+}
+
+// ReplaceSwaps deletes the pair's swaps between from and to and stores the given ones in their
+// place, all in one transaction
+func (s *SQLBackend) ReplaceSwaps(ctx context.Context, pair string, from, to time.Time, swaps []*models.Swap) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return gotils.C(ctx).Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+	_, err = tx.ExecContext(ctx, s.rebind("DELETE FROM "+CollectionSwaps+" WHERE address = $1 AND time >= $2 AND time < $3"), pair, from.Unix(), to.Unix())
+	if err != nil {
+		return gotils.C(ctx).Errorf("error deleting from %v: %v", CollectionSwaps, err)
+	}
+	q := s.rebind(upsert(CollectionSwaps, []string{"tx_hash", "log_index"}, strings.Split(swapCols, ", ")))
+	for _, sw := range swaps {
+		sw.PreSave()
+		_, err = tx.ExecContext(ctx, q,
+			sw.TxHash, sw.LogIndex, sw.Address, sw.Pair, sw.Token0, sw.Token1, sw.BlockNumber, sw.Time.Unix(),
+			sw.From, sw.To, sw.TxFrom, sw.Amount0InS, sw.Amount1InS, sw.Amount0OutS, sw.Amount1OutS, sw.ValueUSDS)
+		if err != nil {
+			return gotils.C(ctx).Errorf("error writing to db: %v", err)
+		}
+	}
+	err = tx.Commit()
+	if err != nil {
+		return gotils.C(ctx).Errorf("error committing swaps: %v", err)
+	}
+	return nil
+}
+
+// ReplaceLiquidityEvents deletes the pair's mints and burns between from and to and stores the given
+// ones in their place, all in one transaction
+func (s *SQLBackend) ReplaceLiquidityEvents(ctx context.Context, pair string, from, to time.Time, events []*models.LiquidityEvent) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return gotils.C(ctx).Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+	_, err = tx.ExecContext(ctx, s.rebind("DELETE FROM "+CollectionLiquidityEvents+" WHERE address = $1 AND time >= $2 AND time < $3"), pair, from.Unix(), to.Unix())
+	if err != nil {
+		return gotils.C(ctx).Errorf("error deleting from %v: %v", CollectionLiquidityEvents, err)
+	}
+	q := s.rebind(upsert(CollectionLiquidityEvents, []string{"tx_hash", "log_index"}, strings.Split(liquidityEventCols, ", ")))
+	for _, e := range events {
+		e.PreSave()
+		_, err = tx.ExecContext(ctx, q,
+			e.TxHash, e.LogIndex, e.Type, e.Address, e.Pair, e.Token0, e.Token1, e.BlockNumber, e.Time.Unix(),
+			e.From, e.To, e.TxFrom, e.Amount0S, e.Amount1S, e.ValueUSDS, e.LiquidityS)
+		if err != nil {
+			return gotils.C(ctx).Errorf("error writing to db: %v", err)
+		}
+	}
+	err = tx.Commit()
+	if err != nil {
+		return gotils.C(ctx).Errorf("error committing liquidity events: %v", err)
+	}
+	return nil
+}
+
+// GetLastCheck returns the collector's global checkpoint, or an empty one if there isn't one yet
+func (s *SQLBackend) GetLastCheck(ctx context.Context) (*models.LastCheck, error) {
+	lc := &models.LastCheck{}
+	var data string
+	err := s.db.QueryRowContext(ctx, s.rebind("SELECT data FROM "+CollectionTimestamps+" WHERE id = $1"), "last_check").Scan(&data)
+	if err == sql.ErrNoRows {
+		return lc, nil
+	}
+	if err != nil {
+		return nil, gotils.C(ctx).Errorf("error getting lastcheck: %v", err)
+	}
+	err = json.Unmarshal([]byte(data), lc)
+	if err != nil {
+		return nil, gotils.C(ctx).Errorf("error decoding lastcheck: %v", err)
+	}
+	return lc, nil
+}
+
+// SaveLastCheck stores the collector's global checkpoint
+func (s *SQLBackend) SaveLastCheck(ctx context.Context, lc *models.LastCheck) error {
+	data, err := json.Marshal(lc)
+	if err != nil {
+		return gotils.C(ctx).Errorf("error encoding lastcheck: %v", err)
+	}
+	_, err = s.db.ExecContext(ctx, s.rebind(upsert(CollectionTimestamps, []string{"id"}, []string{"id", "data"})), "last_check", string(data))
+	if err != nil {
+		return gotils.C(ctx).Errorf("error saving last check: %v", err)
+	}
+	return nil
+}
+
+// GetPairChecks returns the collector's checkpoint for each pair, by pair address
+func (s *SQLBackend) GetPairChecks(ctx context.Context) (map[string]*models.PairCheck, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT address, last_check_at, last_block_number FROM "+CollectionPairChecks)
+	if err != nil {
+		return nil, gotils.C(ctx).Errorf("error getting data: %v", err)
+	}
+	defer rows.Close()
+
+	pcs := map[string]*models.PairCheck{}
+	for rows.Next() {
+		pc := new(models.PairCheck)
+		var checkAt int64
+		err = rows.Scan(&pc.Address, &checkAt, &pc.LastBlockNumber)
+		if err != nil {
+			return nil, gotils.C(ctx).Errorf("%v", err)
+		}
+		if checkAt != 0 {
+			pc.LastCheckAt = time.Unix(checkAt, 0)
+		}
+		pcs[pc.Address] = pc
+	}
+	return pcs, rows.Err()
+}
+
+// SavePairCheck stores the collector's checkpoint for a pair, keyed by pair address
+func (s *SQLBackend) SavePairCheck(ctx context.Context, pc *models.PairCheck) error {
+	var checkAt int64
+	if !pc.LastCheckAt.IsZero() {
+		checkAt = pc.LastCheckAt.Unix()
+	}
+	_, err := s.db.ExecContext(ctx, s.rebind(upsert(CollectionPairChecks, []string{"address"}, []string{"address", "last_check_at", "last_block_number"})),
+		pc.Address, checkAt, pc.LastBlockNumber)
+	if err != nil {
+		return gotils.C(ctx).Errorf("error storing pair check: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,805 @@
+package backend
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/goswap/stats-api/models"
+	"github.com/shopspring/decimal"
+)
+
+func TestSQLPairBuckets(t *testing.T) {
+	ctx := context.Background()
+	db, err := NewSQL(ctx, DriverSQLite, ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	start := time.Now().Truncate(time.Hour)
+	one := decimal.NewFromFloat(1)
+	two := decimal.NewFromFloat(2)
+
+	for i := 0; i < 3; i++ {
+		err = db.SavePairBucket(ctx, &models.PairBucket{
+			Address:   "0x0",
+			Pair:      "A-B",
+			Time:      start.Add(time.Duration(i) * time.Hour),
+			Amount0In: one,
+			VolumeUSD: two,
+			Price0USD: decimal.NewFromInt(int64(i + 1)),
+			Reserve0:  decimal.NewFromInt(int64(10 * (i + 1))),
+			MintCount: 1,
+			TWAP0:     decimal.NewFromInt(int64(i + 1)),
+
+			TWAPSeconds:      3600,
+			Price0Cumulative: decimal.NewFromInt(int64(100 * (i + 1))),
+			CumulativeAt:     start.Add(time.Duration(i)*time.Hour + 59*time.Minute),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	// overwrite the first one
+	err = db.SavePairBucket(ctx, &models.PairBucket{Address: "0x0", Pair: "A-B", Time: start, Amount0In: one, VolumeUSD: one, Price0USD: one, Reserve0: one})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		frame time.Duration
+
+		n         int
+		volumeUSD decimal.Decimal
+		price0USD decimal.Decimal
+		reserve0  decimal.Decimal
+		mintCount int
+		twap0     decimal.Decimal
+	}{
+		{time.Hour, 3, two, decimal.NewFromInt(3), decimal.NewFromInt(30), 1, decimal.NewFromInt(3)},
+		// the overwritten bucket has no twap, so it doesn't count
+		{3 * time.Hour, 1, decimal.NewFromInt(5), decimal.NewFromInt(3), decimal.NewFromInt(30), 2, decimal.NewFromFloat(2.5)},
+	}
+
+	for i, test := range tests {
+		pbs, err := db.GetPairBuckets(ctx, "0x0", start.Add(-time.Minute), start.Add(3*time.Hour), test.frame)
+		if err != nil {
+			t.Fatalf("test %v | %v", i, err)
+		}
+		if len(pbs) != test.n {
+			t.Fatalf("test %v | expected %v buckets, got %v", i, test.n, len(pbs))
+		}
+		last := pbs[len(pbs)-1]
+		if !last.VolumeUSD.Equal(test.volumeUSD) || !last.Price0USD.Equal(test.price0USD) ||
+			!last.Reserve0.Equal(test.reserve0) || last.MintCount != test.mintCount || !last.TWAP0.Equal(test.twap0) {
+			t.Errorf("test %v | results mismatch: %+v", i, last)
+		}
+		if !last.Time.Equal(start.Add(2 * time.Hour)) {
+			t.Errorf("test %v | expected time of latest bucket, got %v", i, last.Time)
+		}
+		if !last.Price0Cumulative.Equal(decimal.NewFromInt(300)) || !last.CumulativeAt.Equal(start.Add(2*time.Hour+59*time.Minute)) {
+			t.Errorf("test %v | expected cumulative price of latest bucket, got %v at %v", i, last.Price0Cumulative, last.CumulativeAt)
+		}
+	}
+}
+
+func TestSQLCandles(t *testing.T) {
+	ctx := context.Background()
+	sqlDB, err := NewSQL(ctx, DriverSQLite, ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sqlDB.Close()
+
+	start := time.Now().Truncate(time.Hour)
+	d := decimal.RequireFromString
+	// the middle hour had no prices
+	candles := []models.OHLC{
+		{Open: d("2"), High: d("3"), Low: d("1.5"), Close: d("2.5")},
+		{},
+		{Open: d("2.4"), High: d("2.6"), Low: d("2.2"), Close: d("2.3")},
+	}
+	want := models.OHLC{Open: d("2"), High: d("3"), Low: d("1.5"), Close: d("2.3")}
+
+	for name, db := range map[string]Backend{"sql": sqlDB, "mock": NewMock()} {
+		for i, c := range candles {
+			bt := start.Add(time.Duration(i) * time.Hour)
+			err = db.SavePairBucket(ctx, &models.PairBucket{Address: "0x0", Pair: "A-B", Time: bt, OHLC0: c})
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = db.SaveTokenBucket(ctx, &models.TokenBucket{Address: "0xa", Symbol: "A", Time: bt, OHLCUSD: c})
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		pbs, err := db.GetPairBuckets(ctx, "0x0", start.Add(-time.Minute), start.Add(3*time.Hour), 3*time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tbs, err := db.GetTokenBuckets(ctx, "0xa", start.Add(-time.Minute), start.Add(3*time.Hour), 3*time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(pbs) != 1 || len(tbs) != 1 {
+			t.Fatalf("%v | expected 1 bucket each, got %v %v", name, len(pbs), len(tbs))
+		}
+		for kind, got := range map[string]models.OHLC{"pair": pbs[0].OHLC0, "token": tbs[0].OHLCUSD} {
+			if !got.Open.Equal(want.Open) || !got.High.Equal(want.High) || !got.Low.Equal(want.Low) || !got.Close.Equal(want.Close) {
+				t.Errorf("%v %v | expected %+v, got %+v", name, kind, want, got)
+			}
+		}
+	}
+}
+
+func TestSQLFeeAPR(t *testing.T) {
+	ctx := context.Background()
+	db, err := NewSQL(ctx, DriverSQLite, ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	start := time.Now().Truncate(time.Hour)
+	// liquidity of 100, 200 and 300 averages 200
+	for i := 0; i < 3; i++ {
+		err = db.SavePairBucket(ctx, &models.PairBucket{
+			Address:   "0x0",
+			Pair:      "A-B",
+			Time:      start.Add(time.Duration(i) * time.Hour),
+			FeesUSD:   decimal.NewFromInt(1),
+			Price0USD: decimal.NewFromInt(1),
+			Reserve0:  decimal.NewFromInt(int64(100 * (i + 1))),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	// an empty pair doesn't get an APR
+	err = db.SavePairBucket(ctx, &models.PairBucket{Address: "0x1", Pair: "C-D", Time: start, FeesUSD: decimal.NewFromInt(1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	from, to := start.Add(-time.Hour), start.Add(3*time.Hour)
+	pbs, err := db.GetPairBuckets(ctx, "", from, to, to.Sub(from))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = AddFeeAPRs(ctx, db, pbs, from, to)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pbs) != 2 {
+		t.Fatalf("expected 2 pairs, got %v", len(pbs))
+	}
+	for _, pb := range pbs {
+		want := decimal.Zero
+		if pb.Address == "0x0" {
+			// $3 of fees over 4 hours on $200 is 3/200 * 8760/4
+			want = decimal.RequireFromString("32.85")
+			if !pb.FeesUSD.Equal(decimal.NewFromInt(3)) {
+				t.Errorf("expected the fees summed, got %v", pb.FeesUSD)
+			}
+		}
+		if !pb.FeeAPR.Equal(want) {
+			t.Errorf("%v | expected APR %v, got %v", pb.Address, want, pb.FeeAPR)
+		}
+	}
+}
+
+func TestSQLFeeAPRUnaligned(t *testing.T) {
+	ctx := context.Background()
+	db, err := NewSQL(ctx, DriverSQLite, ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	day := time.Date(2021, 3, 10, 0, 0, 0, 0, time.UTC)
+	var rollups []*models.PairBucket
+	for i := 0; i < 48; i++ {
+		b := &models.PairBucket{
+			Address:   "0x0",
+			Pair:      "A-B",
+			Time:      day.Add(-Day + time.Duration(i)*time.Hour),
+			FeesUSD:   decimal.NewFromInt(int64(i)),
+			Price0USD: decimal.NewFromInt(1),
+			Reserve0:  decimal.NewFromInt(100),
+		}
+		err = db.SavePairBucket(ctx, b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if i%24 == 0 {
+			rollups = append(rollups, &models.PairBucket{Address: "0x0", Pair: "A-B", Time: b.Time, Price0USD: b.Price0USD, Reserve0: b.Reserve0})
+		}
+		rollups[len(rollups)-1].FeesUSD = rollups[len(rollups)-1].FeesUSD.Add(b.FeesUSD)
+	}
+	for _, r := range rollups {
+		err = db.ReplaceRollups(ctx, Day, r.Time, []*models.PairBucket{r}, nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the last 24h covers the hours from 14:00 yesterday to 13:00 today
+	to := day.Add(13*time.Hour + 30*time.Minute)
+	from := to.Add(-Day)
+	pbs, err := db.GetPairBuckets(ctx, "", from, to, to.Sub(from))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = AddFeeAPRs(ctx, db, pbs, from, to)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pbs) != 1 {
+		t.Fatalf("expected 1 pair, got %v", len(pbs))
+	}
+	if !pbs[0].FeesUSD.Equal(decimal.NewFromInt(612)) {
+		t.Errorf("expected the fees of the hours in the window, got %v", pbs[0].FeesUSD)
+	}
+	// $612 of fees over a day on $100 is 612/100 * 365
+	if want := decimal.RequireFromString("2233.8"); !pbs[0].FeeAPR.Equal(want) {
+		t.Errorf("expected APR %v, got %v", want, pbs[0].FeeAPR)
+	}
+}
+
+func TestSQLExactSums(t *testing.T) {
+	ctx := context.Background()
+	db, err := NewSQL(ctx, DriverSQLite, ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	// more digits than a float64 holds
+	amount := decimal.RequireFromString("123456789012345678.000000000000000001")
+	start := time.Now().Truncate(time.Hour)
+	for i := 0; i < 2; i++ {
+		bt := start.Add(time.Duration(i) * time.Hour)
+		err = db.SavePairBucket(ctx, &models.PairBucket{Address: "0x0", Pair: "A-B", Time: bt, Amount0In: amount, VolumeUSD: amount})
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = db.SaveTotalBucket(ctx, &models.TotalBucket{Time: bt, VolumeUSD: amount})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want := amount.Add(amount)
+	from, to := start.Add(-time.Second), start.Add(2*time.Hour)
+	pbs, err := db.GetPairBuckets(ctx, "", from, to, 2*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pbs) != 1 || !pbs[0].Amount0In.Equal(want) || !pbs[0].VolumeUSD.Equal(want) {
+		t.Errorf("expected %v, got %+v", want, pbs)
+	}
+	totals, err := db.GetTotals(ctx, from, to, 2*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(totals) != 1 || !totals[0].VolumeUSD.Equal(want) {
+		t.Errorf("expected a total of %v, got %+v", want, totals)
+	}
+}
+
+func TestSQLGroupBy(t *testing.T) {
+	ctx := context.Background()
+	goDB, err := NewSQL(ctx, DriverSQLite, ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer goDB.Close()
+	// sqlite adds these up exactly as floats, so the GROUP BY queries Postgres uses can run on it too
+	groupDB, err := NewSQL(ctx, DriverSQLite, ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer groupDB.Close()
+	groupDB.groupBy = true
+
+	start := time.Now().Truncate(time.Hour)
+	d := decimal.RequireFromString
+	candles := []models.OHLC{
+		{Open: d("2"), High: d("3"), Low: d("1.5"), Close: d("2.5")},
+		{},
+		{Open: d("2.4"), High: d("2.6"), Low: d("2.2"), Close: d("2.3")},
+	}
+	for _, db := range []*SQLBackend{goDB, groupDB} {
+		for i, c := range candles {
+			bt := start.Add(time.Duration(i) * time.Hour)
+			n := decimal.NewFromInt(int64(i + 1))
+			traders := []string{"0xa", fmt.Sprint("0x", i)}
+			pb := &models.PairBucket{
+				Address: "0x0", Pair: "A-B", Time: bt,
+				Amount0In: n, VolumeUSD: n, FeesUSD: n, Price0USD: n, Reserve0: n.Mul(n),
+				SwapCount: 2, TWAP0: n.Add(d("0.5")), TWAPSeconds: 1800, OHLC0: c,
+			}
+			pb.AddTraders(traders...)
+			tb := &models.TokenBucket{Address: "0xa", Symbol: "A", Time: bt, AmountIn: n, VolumeUSD: n, PriceUSD: n, SwapCount: 2, OHLCUSD: c}
+			tb.AddTraders(traders...)
+			total := &models.TotalBucket{Time: bt, VolumeUSD: n, LiquidityUSD: n.Mul(n), SwapCount: 2}
+			total.AddTraders(traders...)
+			err = db.SavePairBucket(ctx, pb)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = db.SaveTokenBucket(ctx, tb)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = db.SaveTotalBucket(ctx, total)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	for _, frame := range []time.Duration{time.Hour, 2 * time.Hour, 3 * time.Hour} {
+		from, to := start.Add(-time.Minute), start.Add(3*time.Hour)
+		var got [2]string
+		for i, db := range []*SQLBackend{goDB, groupDB} {
+			pbs, err := db.GetPairBuckets(ctx, "", from, to, frame)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tbs, err := db.GetTokenBuckets(ctx, "0xa", from, to, frame)
+			if err != nil {
+				t.Fatal(err)
+			}
+			totals, err := db.GetTotals(ctx, from, to, frame)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, pb := range pbs {
+				got[i] += fmt.Sprintln(pb.Time.Unix(), pb.Amount0In, pb.VolumeUSD, pb.FeesUSD, pb.Price0USD, pb.Reserve0, pb.SwapCount, pb.UniqueTraders,
+					pb.TWAP0, pb.TWAPSeconds, pb.OHLC0.Open, pb.OHLC0.High, pb.OHLC0.Low, pb.OHLC0.Close)
+			}
+			for _, tb := range tbs {
+				got[i] += fmt.Sprintln(tb.Time.Unix(), tb.AmountIn, tb.VolumeUSD, tb.PriceUSD, tb.SwapCount, tb.UniqueTraders,
+					tb.OHLCUSD.Open, tb.OHLCUSD.High, tb.OHLCUSD.Low, tb.OHLCUSD.Close)
+			}
+			for _, total := range totals {
+				got[i] += fmt.Sprintln(total.Time.Unix(), total.VolumeUSD, total.LiquidityUSD, total.SwapCount, total.UniqueTraders)
+			}
+		}
+		if got[0] != got[1] {
+			t.Errorf("%v | expected GROUP BY to match rolling up in Go:\n%v\ngot:\n%v", frame, got[0], got[1])
+		}
+	}
+}
+
+func TestSQLRollups(t *testing.T) {
+	ctx := context.Background()
+	db, err := NewSQL(ctx, DriverSQLite, ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	day := RollupStart(time.Now(), Day)
+	hourly := &models.PairBucket{Address: "0x0", Pair: "A-B", Time: day, VolumeUSD: decimal.NewFromInt(1)}
+	err = db.SavePairBucket(ctx, hourly)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.ReplaceRollups(ctx, Day, day, []*models.PairBucket{
+		{Address: "0x0", Pair: "A-B", Time: day, VolumeUSD: decimal.NewFromInt(24)},
+		{Address: "0x1", Pair: "A-C", Time: day, VolumeUSD: decimal.NewFromInt(5)},
+	}, nil, []*models.TotalBucket{{Time: day, VolumeUSD: decimal.NewFromInt(29)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// replacing drops the pair that isn't in the period anymore
+	err = db.ReplaceRollups(ctx, Day, day, []*models.PairBucket{
+		{Address: "0x0", Pair: "A-B", Time: day, VolumeUSD: decimal.NewFromInt(20)},
+	}, nil, []*models.TotalBucket{{Time: day, VolumeUSD: decimal.NewFromInt(20)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	from, to := day.Add(-time.Second), day.Add(Day)
+	pbs, err := db.GetPairBuckets(ctx, "", from, to, Day)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pbs) != 1 || !pbs[0].VolumeUSD.Equal(decimal.NewFromInt(20)) || !pbs[0].Time.Equal(day) {
+		t.Errorf("expected the day rollup, got %+v", pbs)
+	}
+	totals, err := db.GetTotals(ctx, from, to, Day)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(totals) != 1 || !totals[0].VolumeUSD.Equal(decimal.NewFromInt(20)) {
+		t.Errorf("expected the day rollup total, got %+v", totals)
+	}
+	// hourly reads are left alone
+	pbs, err = db.GetPairBuckets(ctx, "", from, to, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pbs) != 1 || !pbs[0].VolumeUSD.Equal(decimal.NewFromInt(1)) {
+		t.Errorf("expected the hourly bucket, got %+v", pbs)
+	}
+}
+
+func TestSQLRollupsUnaligned(t *testing.T) {
+	ctx := context.Background()
+	db, err := NewSQL(ctx, DriverSQLite, ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	day := time.Date(2021, 3, 10, 0, 0, 0, 0, time.UTC)
+	var rollups []*models.PairBucket
+	for i := 0; i < 48; i++ {
+		b := &models.PairBucket{Address: "0x0", Pair: "A-B", Time: day.Add(-Day + time.Duration(i)*time.Hour), VolumeUSD: decimal.NewFromInt(int64(i))}
+		err = db.SavePairBucket(ctx, b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if i%24 == 0 {
+			rollups = append(rollups, &models.PairBucket{Address: "0x0", Pair: "A-B", Time: b.Time})
+		}
+		rollups[len(rollups)-1].VolumeUSD = rollups[len(rollups)-1].VolumeUSD.Add(b.VolumeUSD)
+	}
+	for _, r := range rollups {
+		err = db.ReplaceRollups(ctx, Day, r.Time, []*models.PairBucket{r}, nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the last 24h starts partway through yesterday, so it can't be read from the day rollups
+	now := day.Add(13*time.Hour + 30*time.Minute)
+	from := now.Add(-Day)
+	hourly, err := db.GetPairBuckets(ctx, "", from, now, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var want decimal.Decimal
+	for _, b := range hourly {
+		want = want.Add(b.VolumeUSD)
+	}
+	if len(hourly) != 24 || !want.Equal(decimal.NewFromInt(612)) {
+		t.Fatalf("expected 24 hourly buckets adding up to 612, got %v adding up to %v", len(hourly), want)
+	}
+	pbs, err := db.GetPairBuckets(ctx, "", from, now, Day)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pbs) != 1 || !pbs[0].VolumeUSD.Equal(want) {
+		t.Errorf("expected the hourly sum %v, got %+v", want, pbs)
+	}
+}
+
+func TestSQLTraders(t *testing.T) {
+	ctx := context.Background()
+	db, err := NewSQL(ctx, DriverSQLite, ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	start := time.Now().Truncate(time.Hour)
+	for i, traders := range [][]string{{"0xa", "0xb"}, {"0xb", "0xc"}, nil} {
+		b := &models.PairBucket{Address: "0x0", Pair: "A-B", Time: start.Add(time.Duration(i) * time.Hour), SwapCount: len(traders)}
+		b.AddTraders(traders...)
+		err = db.SavePairBucket(ctx, b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tb := &models.TotalBucket{Time: b.Time, SwapCount: b.SwapCount}
+		tb.AddTraders(traders...)
+		err = db.SaveTotalBucket(ctx, tb)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		frame time.Duration
+
+		n, swaps, traders int
+	}{
+		{time.Hour, 3, 0, 0},
+		// 0xb traded in both hours but only counts once
+		{3 * time.Hour, 1, 4, 3},
+	}
+	for i, test := range tests {
+		pbs, err := db.GetPairBuckets(ctx, "0x0", start.Add(-time.Minute), start.Add(3*time.Hour), test.frame)
+		if err != nil {
+			t.Fatal(err)
+		}
+		totals, err := db.GetTotals(ctx, start.Add(-time.Minute), start.Add(3*time.Hour), test.frame)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(pbs) != test.n || len(totals) != test.n {
+			t.Fatalf("test %v | expected %v buckets, got %v and %v", i, test.n, len(pbs), len(totals))
+		}
+		pb, total := pbs[len(pbs)-1], totals[len(totals)-1]
+		if pb.SwapCount != test.swaps || pb.UniqueTraders != test.traders {
+			t.Errorf("test %v | expected %v swaps by %v traders, got %v by %v", i, test.swaps, test.traders, pb.SwapCount, pb.UniqueTraders)
+		}
+		if total.SwapCount != test.swaps || total.UniqueTraders != test.traders {
+			t.Errorf("test %v | expected %v total swaps by %v traders, got %v by %v", i, test.swaps, test.traders, total.SwapCount, total.UniqueTraders)
+		}
+	}
+}
+
+func TestSQLTradersCapped(t *testing.T) {
+	ctx := context.Background()
+	db, err := NewSQL(ctx, DriverSQLite, ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	// 3 hours of 10000 traders each, half of them trading again the next hour
+	start := time.Now().Truncate(time.Hour)
+	hours := make([]*models.TotalBucket, 3)
+	for i := range hours {
+		traders := make([]string, models.MaxTraders)
+		for j := range traders {
+			traders[j] = fmt.Sprintf("0x%040x", i*models.MaxTraders/2+j)
+		}
+		hours[i] = &models.TotalBucket{Time: start.Add(time.Duration(i) * time.Hour)}
+		hours[i].AddTraders(traders...)
+		err = db.SaveTotalBucket(ctx, hours[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := 2 * models.MaxTraders
+
+	totals, err := db.GetTotals(ctx, start.Add(-time.Minute), start.Add(3*time.Hour), 3*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(totals) != 1 || len(totals[0].Traders) != models.MaxTraders {
+		t.Fatalf("expected 1 total keeping %v traders, got %+v", models.MaxTraders, totals)
+	}
+	if got := totals[0].UniqueTraders; got < want*97/100 || got > want*103/100 {
+		t.Errorf("expected about %v traders, got %v", want, got)
+	}
+	// rolling up in another order keeps the same ones
+	b := &models.TotalBucket{}
+	for i := len(hours) - 1; i >= 0; i-- {
+		b.AddFlows(hours[i])
+	}
+	if !reflect.DeepEqual(b.Traders, totals[0].Traders) || b.UniqueTraders != totals[0].UniqueTraders {
+		t.Errorf("expected the same traders whichever order buckets are rolled up in")
+	}
+}
+
+func TestSQLSwaps(t *testing.T) {
+	ctx := context.Background()
+	sqlDB, err := NewSQL(ctx, DriverSQLite, ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sqlDB.Close()
+
+	start := time.Now().Truncate(time.Hour)
+	swaps := func() []*models.Swap {
+		return []*models.Swap{
+			{Address: "0x1", Token0: "0xa", Token1: "0xb", TxHash: "0x01", BlockNumber: 1, TxFrom: "0xw1", To: "0xw1", ValueUSD: decimal.NewFromInt(5)},
+			{Address: "0x1", Token0: "0xa", Token1: "0xb", TxHash: "0x02", BlockNumber: 2, TxFrom: "0xw2", To: "0xw2", ValueUSD: decimal.NewFromInt(50)},
+			{Address: "0x2", Token0: "0xb", Token1: "0xc", TxHash: "0x02", BlockNumber: 2, LogIndex: 1, TxFrom: "0xw1", To: "0xw1", ValueUSD: decimal.NewFromInt(100)},
+			{Address: "0x2", Token0: "0xb", Token1: "0xc", TxHash: "0x03", BlockNumber: 3, TxFrom: "0xw2", To: "0xw1", ValueUSD: decimal.NewFromInt(1)},
+			{Address: "0x1", Token0: "0xa", Token1: "0xb", TxHash: "0x04", BlockNumber: 4, TxFrom: "0xw1", To: "0xw1", ValueUSD: decimal.NewFromInt(500)},
+		}
+	}
+
+	for name, db := range map[string]Backend{"sql": sqlDB, "mock": NewMock()} {
+		for _, pair := range []string{"0x1", "0x2"} {
+			var pairSwaps []*models.Swap
+			for i, s := range swaps() {
+				s.Time = start.Add(time.Duration(i) * time.Minute)
+				if s.Address == pair {
+					pairSwaps = append(pairSwaps, s)
+				}
+			}
+			err = db.ReplaceSwaps(ctx, pair, start, start.Add(time.Hour), pairSwaps)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		tests := []struct {
+			f *SwapFilter
+
+			n     int
+			first string
+		}{
+			{&SwapFilter{}, 5, "0x04"},
+			{&SwapFilter{Pair: "0x1"}, 3, "0x04"},
+			{&SwapFilter{Token: "0xc"}, 2, "0x03"},
+			{&SwapFilter{Token: "0xb"}, 5, "0x04"},
+			// 0x03 was sent by someone else but 0xw1 got the tokens
+			{&SwapFilter{Wallet: "0xw1"}, 4, "0x04"},
+			{&SwapFilter{MinUSD: decimal.NewFromInt(50)}, 3, "0x04"},
+			{&SwapFilter{From: start.Add(time.Minute), To: start.Add(3 * time.Minute)}, 2, "0x02"},
+			{&SwapFilter{Limit: 2}, 2, "0x04"},
+		}
+		for i, test := range tests {
+			got, _, err := db.GetSwaps(ctx, test.f)
+			if err != nil {
+				t.Fatalf("%v test %v | %v", name, i, err)
+			}
+			if len(got) != test.n || got[0].TxHash != test.first {
+				t.Errorf("%v test %v | expected %v swaps starting with %v, got %v", name, i, test.n, test.first, len(got))
+			}
+		}
+
+		// paging through two at a time gets every swap once, in order, and stops at the last page
+		var paged []string
+		f := &SwapFilter{Limit: 2}
+		pages := 0
+		for ; pages < 5; pages++ {
+			got, next, err := db.GetSwaps(ctx, f)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, s := range got {
+				paged = append(paged, fmt.Sprintf("%v/%v", s.TxHash, s.LogIndex))
+			}
+			if next == nil {
+				break
+			}
+			f.Cursor, err = ParseEventCursor(next.String())
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		if want := "[0x04/0 0x03/0 0x02/1 0x02/0 0x01/0]"; fmt.Sprint(paged) != want || pages != 2 {
+			t.Errorf("%v | expected 3 pages of %v, got %v of %v", name, want, pages+1, paged)
+		}
+
+		// replacing the pair's first two minutes leaves the other pair alone
+		err = db.ReplaceSwaps(ctx, "0x1", start, start.Add(2*time.Minute), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, _, err := db.GetSwaps(ctx, &SwapFilter{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != 3 {
+			t.Errorf("%v | expected 3 swaps left, got %v", name, len(got))
+		}
+	}
+}
+
+func TestSQLSwapsMinUSD(t *testing.T) {
+	ctx := context.Background()
+	sqlDB, err := NewSQL(ctx, DriverSQLite, ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sqlDB.Close()
+
+	// these are all the same float64
+	start := time.Now().Truncate(time.Hour)
+	min := decimal.RequireFromString("1000000000000000000.000000000000000002")
+	var swaps []*models.Swap
+	for i, v := range []string{"1000000000000000000.000000000000000003", "1000000000000000000.000000000000000001", "1000000000000000000.000000000000000002"} {
+		swaps = append(swaps, &models.Swap{Address: "0x1", TxHash: fmt.Sprint("0x0", i), BlockNumber: int64(i), Time: start.Add(time.Duration(i) * time.Minute), ValueUSD: decimal.RequireFromString(v)})
+	}
+
+	for name, db := range map[string]Backend{"sql": sqlDB, "mock": NewMock()} {
+		err = db.ReplaceSwaps(ctx, "0x1", start, start.Add(time.Hour), swaps)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		f := &SwapFilter{MinUSD: min, Limit: 1}
+		for pages := 0; pages < 3; pages++ {
+			page, next, err := db.GetSwaps(ctx, f)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, s := range page {
+				got = append(got, s.TxHash)
+			}
+			if next == nil {
+				break
+			}
+			f.Cursor = next
+		}
+		if want := "[0x02 0x00]"; fmt.Sprint(got) != want {
+			t.Errorf("%v | expected %v, got %v", name, want, got)
+		}
+	}
+}
+
+func TestSQLLiquidityEvents(t *testing.T) {
+	ctx := context.Background()
+	sqlDB, err := NewSQL(ctx, DriverSQLite, ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sqlDB.Close()
+
+	start := time.Now().Truncate(time.Hour)
+	events := func() []*models.LiquidityEvent {
+		return []*models.LiquidityEvent{
+			{Type: models.LiquidityMint, Address: "0x1", TxHash: "0x01", BlockNumber: 1, TxFrom: "0xw1", To: "0xw1", Liquidity: decimal.NewFromInt(100), ValueUSD: decimal.NewFromInt(20)},
+			{Type: models.LiquidityMint, Address: "0x2", TxHash: "0x02", BlockNumber: 2, TxFrom: "0xw2", To: "0xw1", Liquidity: decimal.NewFromInt(10), ValueUSD: decimal.NewFromInt(2)},
+			{Type: models.LiquidityBurn, Address: "0x1", TxHash: "0x03", BlockNumber: 3, TxFrom: "0xw1", To: "0xw1", Liquidity: decimal.NewFromInt(50), ValueUSD: decimal.NewFromInt(10)},
+			{Type: models.LiquidityBurn, Address: "0x1", TxHash: "0x03", BlockNumber: 3, LogIndex: 1, TxFrom: "0xw2", To: "0xw2", Liquidity: decimal.NewFromInt(5), ValueUSD: decimal.NewFromInt(1)},
+		}
+	}
+
+	for name, db := range map[string]Backend{"sql": sqlDB, "mock": NewMock()} {
+		for _, pair := range []string{"0x1", "0x2"} {
+			var pairEvents []*models.LiquidityEvent
+			for i, e := range events() {
+				e.Time = start.Add(time.Duration(i) * time.Minute)
+				if e.Address == pair {
+					pairEvents = append(pairEvents, e)
+				}
+			}
+			err = db.ReplaceLiquidityEvents(ctx, pair, start, start.Add(time.Hour), pairEvents)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		tests := []struct {
+			f *LiquidityEventFilter
+
+			n     int
+			first string
+		}{
+			{&LiquidityEventFilter{}, 4, "0x03/1"},
+			{&LiquidityEventFilter{Pair: "0x2"}, 1, "0x02/0"},
+			{&LiquidityEventFilter{Type: models.LiquidityMint}, 2, "0x02/0"},
+			// 0x02 was sent by someone else but 0xw1 got the LP tokens
+			{&LiquidityEventFilter{Wallet: "0xw1"}, 3, "0x03/0"},
+			{&LiquidityEventFilter{Pair: "0x1", Type: models.LiquidityBurn, Wallet: "0xw2"}, 1, "0x03/1"},
+			{&LiquidityEventFilter{From: start.Add(time.Minute), To: start.Add(3 * time.Minute)}, 2, "0x03/0"},
+			{&LiquidityEventFilter{Cursor: NewEventCursor(start.Add(3*time.Minute), 3, 1)}, 3, "0x03/0"},
+			{&LiquidityEventFilter{Limit: 1}, 1, "0x03/1"},
+		}
+		for i, test := range tests {
+			got, _, err := db.GetLiquidityEvents(ctx, test.f)
+			if err != nil {
+				t.Fatalf("%v test %v | %v", name, i, err)
+			}
+			if len(got) != test.n || fmt.Sprintf("%v/%v", got[0].TxHash, got[0].LogIndex) != test.first {
+				t.Errorf("%v test %v | expected %v events starting with %v, got %v", name, i, test.n, test.first, len(got))
+			}
+		}
+
+		got, _, err := db.GetLiquidityEvents(ctx, &LiquidityEventFilter{Pair: "0x2"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if e := got[0]; e.Type != models.LiquidityMint || !e.Liquidity.Equal(decimal.NewFromInt(10)) || !e.ValueUSD.Equal(decimal.NewFromInt(2)) {
+			t.Errorf("%v | expected the mint of 10 LP tokens worth $2, got %v of %v worth $%v", name, e.Type, e.Liquidity, e.ValueUSD)
+		}
+
+		err = db.ReplaceLiquidityEvents(ctx, "0x1", start, start.Add(time.Hour), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, next, err := db.GetLiquidityEvents(ctx, &LiquidityEventFilter{Limit: 1})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != 1 || next != nil {
+			t.Errorf("%v | expected 1 event left, got %v", name, len(got))
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package collector
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/goswap/stats-api/backend"
+	"github.com/treeder/gotils/v2"
+)
+
+// BlockRangeForTimes returns the first block at or after since and the last block before until.
+// A zero until means up to the latest confirmed block.
+func BlockRangeForTimes(ctx context.Context, rpc ChainReader, since, until time.Time) (int64, int64, error) {
+	num, err := rpc.LatestBlockNumber(ctx)
+	if err != nil {
+		return 0, 0, gotils.C(ctx).Errorf("failed to get latest block number: %v", err)
+	}
+	latest := num.Int64() - ConfirmationDepth
+	fromBlock, err := lastBlockBefore(ctx, rpc, 1, latest, since)
+	if err != nil {
+		return 0, 0, err
+	}
+	toBlock := latest
+	if !until.IsZero() {
+		toBlock, err = lastBlockBefore(ctx, rpc, fromBlock+1, latest, until)
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+	return fromBlock + 1, toBlock, nil
+}
+
+// Backfill replays all the pair events between fromBlock and toBlock and recomputes the events and pair, token and total
+// buckets for those hours from scratch. The range is widened to whole hours so partial buckets never get written,
+// and anything in the last hour that hasn't finished yet is left alone. Running it again over the same range gives
+// the same result. Pair checks aren't touched, the regular collector carries on from where it was.
+func Backfill(ctx context.Context, rpc ChainReader, db backend.Backend, fromBlock, toBlock int64) error {
+	truncateBy := time.Hour
+
+	num, err := rpc.LatestBlockNumber(ctx)
+	if err != nil {
+		return gotils.C(ctx).Errorf("failed to get latest block number: %v", err)
+	}
+	latest := num.Int64() - ConfirmationDepth
+	if toBlock <= 0 || toBlock > latest {
+		toBlock = latest
+	}
+	if fromBlock < 1 {
+		fromBlock = 1
+	}
+	if toBlock < fromBlock {
+		return gotils.C(ctx).Errorf("invalid block range %v to %v", fromBlock, toBlock)
+	}
+
+	// widen to whole hours
+	fromTime, err := GetTimestampByBlockNumber(ctx, rpc, fromBlock)
+	if err != nil {
+		return gotils.C(ctx).Errorf("%v", err)
+	}
+	fromTime = fromTime.Truncate(truncateBy)
+	fromBlock, err = lastBlockBefore(ctx, rpc, 1, fromBlock, fromTime)
+	if err != nil {
+		return gotils.C(ctx).Errorf("%v", err)
+	}
+	fromBlock++
+	toTime, err := GetTimestampByBlockNumber(ctx, rpc, toBlock)
+	if err != nil {
+		return gotils.C(ctx).Errorf("%v", err)
+	}
+	stopAt := toTime.Truncate(truncateBy).Add(truncateBy)
+	latestTime, err := GetTimestampByBlockNumber(ctx, rpc, latest)
+	if err != nil {
+		return gotils.C(ctx).Errorf("%v", err)
+	}
+	if stopAt.After(latestTime.Truncate(truncateBy)) {
+		// that hour isn't over yet
+		stopAt = latestTime.Truncate(truncateBy)
+	}
+	toBlock, err = lastBlockBefore(ctx, rpc, fromBlock, latest, stopAt)
+	if err != nil {
+		return gotils.C(ctx).Errorf("%v", err)
+	}
+	if toBlock < fromBlock {
+		fmt.Printf("No complete hours between %v and %v, nothing to do\n", fromTime, stopAt)
+		return nil
+	}
+	fmt.Printf("Backfilling blocks %v to %v (%v to %v)\n", fromBlock, toBlock, fromTime, stopAt)
+
+	_, err = db.GetTokens(ctx)
+	if err != nil {
+		return gotils.C(ctx).Errorf("error on GetTokens: %v", err)
+	}
+	pairs, err := db.GetPairs(ctx)
+	if err != nil {
+		return gotils.C(ctx).Errorf("error on GetPairs: %v", err)
+	}
+	pairMap, err := setupPairs(ctx, rpc, db, pairs)
+	if err != nil {
+		return gotils.C(ctx).Errorf("error on setupPairs: %v", err)
+	}
+
+	// start clean so buckets that shouldn't exist anymore don't stick around
+	err = db.DeleteBuckets(ctx, fromTime, stopAt)
+	if err != nil {
+		return gotils.C(ctx).Errorf("error on DeleteBuckets: %v", err)
+	}
+
+	touched := map[int64]bool{}
+	for i, p := range pairs {
+		startBlock := fromBlock
+		if p.CreatedBlock > startBlock {
+			startBlock = p.CreatedBlock
+		}
+		if startBlock > toBlock {
+			fmt.Printf("[%v/%v] %v created after range, skipping\n", i+1, len(pairs), p.String())
+			continue
+		}
+		pairBuckets, swaps, liquidityEvents, err := collectPair(ctx, rpc, p, startBlock, toBlock, stopAt, truncateBy)
+		if err != nil {
+			return gotils.C(ctx).Errorf("error collecting %v: %v", p.String(), err)
+		}
+		err = replaceEvents(ctx, db, p, fromTime, stopAt, swaps, liquidityEvents)
+		if err != nil {
+			return err
+		}
+		for t, pb := range pairBuckets {
+			if t < fromTime.Unix() {
+				continue
+			}
+			err = db.SavePairBucket(ctx, pb)
+			if err != nil {
+				return gotils.C(ctx).Errorf("error on SavePairBucket: %v", err)
+			}
+			touched[t] = true
+		}
+		fmt.Printf("[%v/%v] %v done, %v buckets\n", i+1, len(pairs), p.String(), len(pairBuckets))
+	}
+
+	fmt.Printf("Rolling up %v hours\n", len(touched))
+	err = rollupBuckets(ctx, db, pairMap, touched)
+	if err != nil {
+		return gotils.C(ctx).Errorf("error on rollupBuckets: %v", err)
+	}
+	err = rollupPeriods(ctx, db, fromTime, stopAt)
+	if err != nil {
+		return gotils.C(ctx).Errorf("error on rollupPeriods: %v", err)
+	}
+	fmt.Printf("Backfill done, blocks %v to %v\n", fromBlock, toBlock)
+	return nil
+}
@@ -0,0 +1,122 @@
+package collector
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gochain-io/explorer/server/utils"
+	"github.com/gochain/gochain/v4"
+	"github.com/gochain/gochain/v4/accounts/abi"
+	"github.com/gochain/gochain/v4/common"
+	"github.com/gochain/gochain/v4/core/types"
+	"github.com/goswap/stats-api/contracts"
+	"github.com/treeder/gotils/v2"
+)
+
+// BurnEvent represents a Burn event emitted when liquidity is removed from a pair.
+type BurnEvent struct {
+	TxFrom          common.Address // actual user who removed the liquidity
+	Sender          common.Address // typically the router
+	To              common.Address // receiver of the underlying tokens
+	BlockNumber     int64
+	TransactionHash string
+	LogIndex        uint
+	Amount0         *big.Int
+	Amount1         *big.Int
+	Timestamp       time.Time
+}
+
+var burnEventID = common.HexToHash("0xdccd412f0b1252819cb1fd330b93224ca42612892bb3f4f789976e6d81936496")
+
+func GetBurnEvents(ctx context.Context, rpc ChainReader, pairAddress common.Address, startBlock, endBlock int64) ([]*BurnEvent, error) {
+	abi, err := abi.JSON(strings.NewReader(contracts.PairABI))
+	if err != nil {
+		fmt.Println("Failed to parse token Uniswap ABI:", err)
+		os.Exit(1)
+	}
+	ctx = gotils.With(ctx, "address", pairAddress)
+	var burnEvents []*BurnEvent
+	numOfBlocksPerRequest := maxBlockPerRequest
+
+	currentBlock := startBlock
+	for currentBlock <= endBlock {
+		toBlock := currentBlock + numOfBlocksPerRequest
+		if toBlock > endBlock {
+			toBlock = endBlock
+		}
+		fmt.Printf("Querying for burn events, from: %v, to: %v\n",
+			currentBlock, toBlock)
+		query := gochain.FilterQuery{
+			FromBlock: big.NewInt(currentBlock),
+			ToBlock:   big.NewInt(toBlock),
+			Addresses: []common.Address{pairAddress},
+			Topics:    [][]common.Hash{{burnEventID}},
+		}
+
+		var logs []types.Log
+		err := utils.Retry(ctx, 5, 2*time.Second, func() (err error) {
+			logs, err = rpc.FilterLogs(ctx, query)
+			return err
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to query RPC for logs: %v", err)
+		}
+		var currentTimeStamp time.Time
+		var currentBlockNumber int64
+		for _, log := range logs {
+			event, err := unpackBurnEvent(ctx, abi, log)
+			if err != nil {
+				return nil, gotils.C(ctx).Errorf("Failed to unpack event: %v, block: %v, index: %v, contract: %v", err, log.BlockNumber, log.Index, log.Address)
+			}
+			burnEvents = append(burnEvents, event)
+
+			// get who the tx was from
+			tx, _, err := rpc.TransactionByHashFull(ctx, log.TxHash)
+			if err != nil {
+				return nil, gotils.C(ctx).Errorf("Failed to get transaction: %v", err)
+			}
+			event.TxFrom = *tx.From
+
+			if currentBlockNumber != event.BlockNumber || currentTimeStamp.IsZero() {
+				currentTimeStamp, err = GetTimestampByBlockNumber(ctx, rpc, event.BlockNumber)
+				if err != nil {
+					return nil, gotils.C(ctx).Errorf("Failed to get block timestamp: %v", err)
+				}
+			}
+			event.Timestamp = currentTimeStamp
+			currentBlockNumber = event.BlockNumber
+		}
+		currentBlock = toBlock + 1
+	}
+	return burnEvents, nil
+}
+
+func unpackBurnEvent(ctx context.Context, abi abi.ABI, event types.Log) (*BurnEvent, error) {
+	if l := len(event.Topics); l != 3 {
+		return nil, fmt.Errorf("incorrect number of topics: %d", l)
+	}
+	sender := event.Topics[1].Bytes()
+	to := event.Topics[2].Bytes()
+	if len(bytes.TrimPrefix(sender, addrTopicPrefix)) != 20 {
+		return nil, fmt.Errorf("sender topic longer than address: %s", string(sender))
+	}
+	if len(bytes.TrimPrefix(to, addrTopicPrefix)) != 20 {
+		return nil, fmt.Errorf("to topic longer than address: %s", string(to))
+	}
+	var burnEvent BurnEvent
+	err := abi.UnpackIntoInterface(&burnEvent, "Burn", event.Data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unpack log data: %v", err)
+	}
+	burnEvent.Sender = common.BytesToAddress(sender)
+	burnEvent.To = common.BytesToAddress(to)
+	burnEvent.BlockNumber = int64(event.BlockNumber)
+	burnEvent.TransactionHash = event.TxHash.String()
+	burnEvent.LogIndex = event.Index
+	return &burnEvent, nil
+}
@@ -0,0 +1,64 @@
+package collector
+
+import (
+	"sort"
+
+	"github.com/goswap/stats-api/models"
+	"github.com/shopspring/decimal"
+)
+
+// pricePoint is a price of token0 in token1 seen in a pair, from a swap or a Sync
+type pricePoint struct {
+	blockNumber int64
+	logIndex    uint
+	price       decimal.Decimal
+}
+
+// executionPrice returns the price a swap traded token0 at in token1, or false if it didn't trade both
+func executionPrice(amount0In, amount1In, amount0Out, amount1Out decimal.Decimal) (decimal.Decimal, bool) {
+	amount0 := amount0In.Sub(amount0Out).Abs()
+	amount1 := amount1In.Sub(amount1Out).Abs()
+	if amount0.IsZero() || amount1.IsZero() {
+		return decimal.Zero, false
+	}
+	return amount1.DivRound(amount0, 18), true
+}
+
+// spotPrice returns the price of token0 in token1 at the reserves, or false if the pair is empty
+func spotPrice(reserve0, reserve1 decimal.Decimal) (decimal.Decimal, bool) {
+	if !reserve0.IsPositive() || !reserve1.IsPositive() {
+		return decimal.Zero, false
+	}
+	return reserve1.DivRound(reserve0, 18), true
+}
+
+// addCandles fills in each bucket's candle from the prices seen in it, by bucket time, in chain
+// order. Buckets without any are flat at the spot price of their reserves.
+func addCandles(pairBuckets map[int64]*models.PairBucket, points map[int64][]pricePoint) {
+	for ut, pb := range pairBuckets {
+		ps := points[ut]
+		sort.SliceStable(ps, func(i, j int) bool {
+			if ps[i].blockNumber != ps[j].blockNumber {
+				return ps[i].blockNumber < ps[j].blockNumber
+			}
+			return ps[i].logIndex < ps[j].logIndex
+		})
+		for _, pp := range ps {
+			pb.OHLC0.Observe(pp.price)
+		}
+		if pb.OHLC0.IsZero() {
+			if price, ok := spotPrice(pb.Reserve0, pb.Reserve1); ok {
+				pb.OHLC0.Observe(price)
+			}
+		}
+	}
+}
+
+// tokenCandle returns the candle in USD of the pair's token0, or token1 if !token0, from the pair's
+// candle and the other token's price. It's zero if the other token has no price.
+func tokenCandle(pb *models.PairBucket, token0 bool) models.OHLC {
+	if token0 {
+		return pb.OHLC0.Mul(pb.Price1USD)
+	}
+	return pb.OHLC0.Inverse().Mul(pb.Price0USD)
+}
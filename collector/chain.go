@@ -0,0 +1,57 @@
+package collector
+
+import (
+	"context"
+	"errors"
+	"math/big"
+
+	"github.com/gochain/gochain/v4"
+	"github.com/gochain/gochain/v4/accounts/abi/bind"
+	"github.com/gochain/gochain/v4/common"
+	"github.com/gochain/gochain/v4/core/types"
+	"github.com/gochain/gochain/v4/goclient"
+)
+
+// ChainReader is everything the collector reads from the chain. *goclient.Client implements it,
+// and so does fakechain.Chain for running the collector in tests.
+type ChainReader interface {
+	bind.ContractCaller
+	bind.ContractFilterer
+
+	LatestBlockNumber(ctx context.Context) (*big.Int, error)
+	BlockByNumber(ctx context.Context, number *big.Int) (*types.Block, error)
+	HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error)
+	TransactionByHashFull(ctx context.Context, hash common.Hash) (*goclient.TransactionFull, bool, error)
+}
+
+var errReadOnly = errors.New("chain reader is read only")
+
+// readOnly lets a ChainReader be used with the generated contract bindings, which want to be able
+// to send transactions too. We never do.
+type readOnly struct {
+	ChainReader
+}
+
+func (readOnly) PendingCodeAt(ctx context.Context, account common.Address) ([]byte, error) {
+	return nil, errReadOnly
+}
+func (readOnly) PendingNonceAt(ctx context.Context, account common.Address) (uint64, error) {
+	return 0, errReadOnly
+}
+func (readOnly) SuggestGasPrice(ctx context.Context) (*big.Int, error) {
+	return nil, errReadOnly
+}
+func (readOnly) EstimateGas(ctx context.Context, call gochain.CallMsg) (uint64, error) {
+	return 0, errReadOnly
+}
+func (readOnly) SendTransaction(ctx context.Context, tx *types.Transaction) error {
+	return errReadOnly
+}
+
+// contractBackend returns rpc as a bind.ContractBackend for the generated contract bindings
+func contractBackend(rpc ChainReader) bind.ContractBackend {
+	if b, ok := rpc.(bind.ContractBackend); ok {
+		return b
+	}
+	return readOnly{rpc}
+}
@@ -2,15 +2,13 @@ package collector
 
 import (
 	"context"
-	"errors"
 	"fmt"
-	"math/big"
+	"sort"
 	"sync"
 	"time"
 
-	"cloud.google.com/go/firestore"
+	"github.com/gochain/gochain/v4/accounts/abi/bind"
 	"github.com/gochain/gochain/v4/common"
-	"github.com/gochain/gochain/v4/goclient"
 	"github.com/goswap/stats-api/backend"
 	"github.com/goswap/stats-api/contracts"
 	"github.com/goswap/stats-api/models"
@@ -18,8 +16,6 @@ import (
 	"github.com/shopspring/decimal"
 	"github.com/treeder/gotils/v2"
 	"golang.org/x/sync/errgroup"
-	"google.golang.org/grpc/codes"
-	"google.golang.org/grpc/status"
 )
 
 const (
@@ -30,42 +26,68 @@ const (
 )
 
 var (
-	mu        sync.RWMutex
-	TokenMap  = map[string]*models.Token{}
+	// FactoryStartBlock is where to start looking for PairCreated events when no pairs are known yet,
+	// set it to the block the factory was deployed in so the scan doesn't start from genesis
+	FactoryStartBlock = int64(0)
+
+	mu       sync.RWMutex
+	TokenMap = map[string]*models.Token{}
+	// USDCPairs are the pairs with a stablecoin, by the address of the other token
 	USDCPairs = map[string]*models.Pair{}
 )
 
-// GetPairsFromChain returns all the pairs that have been registered in GoSwap via the Factory contract
-// fromIndex will get all the pairs from the current index. Pass in 0 for all.
-func GetPairsFromChain(ctx context.Context, rpc *goclient.Client, fromIndex int) ([]*models.Pair, error) {
+// GetPairsFromChain returns the pairs registered in GoSwap via the Factory contract, discovered from
+// the PairCreated events between fromBlock and toBlock. Pass in FactoryStartBlock for all.
+func GetPairsFromChain(ctx context.Context, rpc ChainReader, fromBlock, toBlock int64) ([]*models.Pair, error) {
 	addr := common.HexToAddress(FactoryAddress)
-	mainContract, err := contracts.NewUniswapFactory(addr, rpc)
+	mainContract, err := contracts.NewUniswapFactory(addr, contractBackend(rpc))
 	if err != nil {
 		return nil, gotils.C(ctx).Errorf("error on NewUniswapFactory: %v", err)
 	}
-	pairsLength, err := mainContract.AllPairsLength(nil)
-	if err != nil {
-		return nil, gotils.C(ctx).Errorf("error on AllPairsLength: %v", err)
+
+	var created []*contracts.UniswapFactoryPairCreated
+	for currentBlock := fromBlock; currentBlock <= toBlock; {
+		end := uint64(currentBlock + maxBlockPerRequest)
+		if end > uint64(toBlock) {
+			end = uint64(toBlock)
+		}
+		fmt.Printf("Querying for PairCreated events, from: %v, to: %v\n", currentBlock, end)
+		iter, err := mainContract.FilterPairCreated(&bind.FilterOpts{Start: uint64(currentBlock), End: &end, Context: ctx}, nil, nil)
+		if err != nil {
+			return nil, gotils.C(ctx).Errorf("error on FilterPairCreated: %v", err)
+		}
+		for iter.Next() {
+			created = append(created, iter.Event)
+		}
+		err = iter.Error()
+		iter.Close()
+		if err != nil {
+			return nil, gotils.C(ctx).Errorf("error iterating PairCreated events: %v", err)
+		}
+		currentBlock = int64(end) + 1
 	}
-	fmt.Printf("Factory contract number of pairs: %v\n", pairsLength.String())
+	fmt.Printf("Factory PairCreated events found: %v\n", len(created))
 
 	pairs := []*models.Pair{}
 	{ // doing this so the errgroup ctx doesn't affect the rest
 		g, ctx := errgroup.WithContext(ctx)
-		for i := fromIndex; i < int(pairsLength.Int64()); i++ {
-			i2 := i // https://golang.org/doc/faq#closures_and_goroutines
-			pairIndex := big.NewInt(int64(i))
+		for _, ev := range created {
+			ev := ev // https://golang.org/doc/faq#closures_and_goroutines
 			g.Go(func() error {
-				address, err := mainContract.AllPairs(nil, pairIndex)
+				// the event carries the new length of allPairs, so index is one less
+				pairIndex := ev.Arg3.Int64() - 1
+				fmt.Printf("%v PAIR: %v\n", pairIndex, ev.Pair.String())
+				pair, err := GetPairDetails(ctx, rpc, ev.Pair)
 				if err != nil {
-					return gotils.C(ctx).Errorf("error on AllPairs call: %v", err)
+					return gotils.C(ctx).Errorf("failed to get token details: %v", err)
 				}
-				fmt.Printf("%v PAIR: %v\n", pairIndex, address.String())
-				pair, err := GetPairDetails(ctx, rpc, address)
+				pair.Index = int(pairIndex)
+				pair.CreatedBlock = int64(ev.Raw.BlockNumber)
+				pair.CreatedTxHash = ev.Raw.TxHash.Hex()
+				pair.CreatedAt, err = GetTimestampByBlockNumber(ctx, rpc, pair.CreatedBlock)
 				if err != nil {
-					return gotils.C(ctx).Errorf("failed to get token details: %v", err)
+					return gotils.C(ctx).Errorf("failed to get creation time: %v", err)
 				}
-				pair.Index = i2
 				fmt.Printf("%v %v %v -- %v %v\n", pairIndex, pair.Token0.Symbol, pair.Token0.Name, pair.Token1.Symbol, pair.Token1.Name)
 				fmt.Printf("%v Addresses: %v -- %v\n", pairIndex, pair.Token0.Address.Hex(), pair.Token1.Address.Hex())
 
@@ -76,21 +98,20 @@ func GetPairsFromChain(ctx context.Context, rpc *goclient.Client, fromIndex int)
 				TokenMap[pair.Token1.Address.Hex()] = pair.Token1
 				// save USDC pairs for valuations
 				// DON'T REMOVE THIS FROM HERE YET, USED IN OTHER STUFF FOR NOW
-				if pair.Token0.Symbol == "USDC" {
-					USDCPairs[pair.Token1.Symbol] = pair
-					p, err := pair.PriceInUSD(ctx)
-					if err != nil {
-						return gotils.C(ctx).Errorf("error getting price: %v", err)
+				for _, quote := range []*models.Token{pair.Token0, pair.Token1} {
+					if !StablecoinAddresses[quote.Address] {
+						continue
 					}
-					fmt.Printf("Current price of %v: %v\n", pair.Token1.Symbol, p)
-				}
-				if pair.Token1.Symbol == "USDC" {
-					USDCPairs[pair.Token0.Symbol] = pair
-					p, err := pair.PriceInUSD(ctx)
+					other := pair.Token0
+					if other == quote {
+						other = pair.Token1
+					}
+					USDCPairs[other.Address.Hex()] = pair
+					p, err := pair.PriceIn(ctx, quote.Address, 0)
 					if err != nil {
 						return gotils.C(ctx).Errorf("error getting price: %v", err)
 					}
-					fmt.Printf("Current price of %v: %v\n", pair.Token0.Symbol, p)
+					fmt.Printf("Current price of %v: %v\n", other.Symbol, p)
 				}
 				// END DON'T REMOVE
 				return nil
@@ -100,29 +121,25 @@ func GetPairsFromChain(ctx context.Context, rpc *goclient.Client, fromIndex int)
 			return nil, err
 		}
 	}
+	sort.Slice(pairs, func(i, j int) bool { return pairs[i].Index < pairs[j].Index })
 	return pairs, nil
 }
 
-func PriceInUSD(ctx context.Context, symbol string) (decimal.Decimal, error) {
-	if symbol == "USDC" {
-		return decimal.NewFromInt(1), nil
-	}
-	p := USDCPairs[symbol]
-	if p == nil {
-		return decimal.Zero, &models.PriceNotFound{}
-	}
-	p2, err := p.PriceInUSD(ctx)
-	// if err == nil {
-	// 	priceMap[symbol] = p2
-	// }
-	return p2, err
+// PriceInUSD returns the USD price of the token from the price graph, which is built from all the
+// pairs in setupPairs. Stablecoins are $1 unless they've depegged. Tokens without a path to a
+// stablecoin return PriceNotFound.
+func PriceInUSD(ctx context.Context, address common.Address) (decimal.Decimal, error) {
+	mu.RLock()
+	g := &priceGraph{prices: tokenPrices, depegged: depeggedStables}
+	mu.RUnlock()
+	return g.price(address)
 }
 
-func GetPairDetails(ctx context.Context, rpc *goclient.Client, contractAddress common.Address) (*models.Pair, error) {
+func GetPairDetails(ctx context.Context, rpc ChainReader, contractAddress common.Address) (*models.Pair, error) {
 	tb := &models.Pair{
 		Address: contractAddress,
 	}
-	p, err := contracts.NewPair(contractAddress, rpc)
+	p, err := contracts.NewPair(contractAddress, contractBackend(rpc))
 	if err != nil {
 		return nil, gotils.C(ctx).Errorf("error on NewPair: %v", err)
 	}
@@ -157,8 +174,8 @@ func GetPairDetails(ctx context.Context, rpc *goclient.Client, contractAddress c
 	return tb, err
 }
 
-func GetErc20Details(ctx context.Context, rpc *goclient.Client, addr common.Address) (*models.Token, error) {
-	t0erc20, err := contracts.NewErc20(addr, rpc)
+func GetErc20Details(ctx context.Context, rpc ChainReader, addr common.Address) (*models.Token, error) {
+	t0erc20, err := contracts.NewErc20(addr, contractBackend(rpc))
 	if err != nil {
 		return nil, gotils.C(ctx).Errorf("Token0: %v", err)
 	}
@@ -178,15 +195,8 @@ func GetErc20Details(ctx context.Context, rpc *goclient.Client, addr common.Addr
 	return td, nil
 }
 
-type LastCheck struct {
-	LastCheckAt     time.Time `firestore:"lastCheckAt" json:"lastCheckAt"`
-	LastBlockNumber int64     `firestore:"lastBlockNumber"  json:"lastBlockNumber"`
-}
-
 // FetchData is the main data collection function
-func FetchData(ctx context.Context, rpc *goclient.Client, fs *firestore.Client) error {
-	var startBlock int64
-
+func FetchData(ctx context.Context, rpc ChainReader, db backend.Backend) error {
 	// set this to how big we want the buckets
 	truncateBy := time.Hour
 
@@ -195,47 +205,77 @@ func FetchData(ctx context.Context, rpc *goclient.Client, fs *firestore.Client)
 	if err != nil {
 		return gotils.C(ctx).Errorf("WARN: Failed to get latest block number: %v", err)
 	}
-	endBlock = num.Int64()
+	// only process blocks that are deep enough to be safe from reorgs
+	endBlock = num.Int64() - ConfirmationDepth
 	latestBlockTimestamp, err := GetTimestampByBlockNumber(ctx, rpc, endBlock)
 	if err != nil {
 		return gotils.C(ctx).Errorf("%v", err)
 	}
 	stopAt := latestBlockTimestamp.Truncate(truncateBy) // don't process past this
 
-	lcRef := fs.Collection(backend.CollectionTimestamps).Doc("last_check")
-	dsnap, err := lcRef.Get(ctx)
-	lc := &LastCheck{}
+	pairChecks, err := db.GetPairChecks(ctx)
 	if err != nil {
-		if status.Code(err) != codes.NotFound {
-			return gotils.C(ctx).Errorf("error getting lastcheck: %v", err)
-		}
-		// no lastcheck, so let's start at a recent block
-		num, err := rpc.LatestBlockNumber(ctx)
-		if err != nil {
-			return gotils.C(ctx).Errorf("Failed to get latest block number: %v", err)
-		}
-		startBlock = num.Int64() - (720 * 2) // about 2 hours, going back 2 because stopAt will prevent the final hour
+		return gotils.C(ctx).Errorf("error on GetPairChecks: %v", err)
+	}
+
+	lc, err := db.GetLastCheck(ctx)
+	if err != nil {
+		return gotils.C(ctx).Errorf("error on GetLastCheck: %v", err)
+	}
+	// set if a reorg deleted buckets, the rollups from here on need rebuilding too
+	var rewoundAt time.Time
+	if lc.LastBlockNumber == 0 {
 		fmt.Printf("no last check\n")
 	} else {
-		err = dsnap.DataTo(lc)
-		if err != nil {
-			return gotils.C(ctx).Errorf("Failed to DataTo: %v", err)
+		if len(lc.Checkpoints) > 0 {
+			// make sure what we processed last time is still on the canonical chain
+			good, err := lastGoodCheckpoint(ctx, rpc, lc)
+			if err != nil {
+				return gotils.C(ctx).Errorf("error checking for reorg: %v", err)
+			}
+			if good != len(lc.Checkpoints)-1 {
+				cp := lc.Checkpoints[good]
+				fmt.Printf("Reorg detected, rewinding to block %v at %v\n", cp.BlockNumber, cp.CheckAt)
+				// anything after the good checkpoint may contain events that no longer exist
+				err = db.DeleteBuckets(ctx, cp.CheckAt, time.Time{})
+				if err != nil {
+					return gotils.C(ctx).Errorf("error deleting reorged buckets: %v", err)
+				}
+				rewoundAt = cp.CheckAt
+				for _, pc := range pairChecks {
+					if pc.LastBlockNumber > cp.BlockNumber {
+						pc.LastBlockNumber = cp.BlockNumber
+						pc.LastCheckAt = cp.CheckAt
+						err = db.SavePairCheck(ctx, pc)
+						if err != nil {
+							return gotils.C(ctx).Errorf("error rewinding pair check: %v", err)
+						}
+					}
+				}
+				lc.Checkpoints = lc.Checkpoints[:good+1]
+				lc.LastCheckAt = cp.CheckAt
+				lc.LastBlockNumber = cp.BlockNumber
+				lc.LastBlockHash = cp.BlockHash
+			}
 		}
-		if lc.LastCheckAt.Equal(stopAt) {
-			// too soon...
-			fmt.Printf("Last check == stopAt, cancelling...\n")
+		fmt.Printf("Last check at %v, block %v\n", lc.LastCheckAt, lc.LastBlockNumber)
+	}
+	// only go up to the last block before stopAt, so every bucket we write is complete and the next
+	// run starts exactly at the next bucket
+	endBlock, err = lastBlockBefore(ctx, rpc, lc.LastBlockNumber+1, endBlock, stopAt)
+	if err != nil {
+		return gotils.C(ctx).Errorf("error finding last block before %v: %v", stopAt, err)
+	}
+	if endBlock <= lc.LastBlockNumber {
+		// nothing new, but pairs that failed last time are still behind the last check
+		if !pairsBehind(pairChecks, lc.LastBlockNumber) {
+			fmt.Printf("No blocks before %v, cancelling...\n", stopAt)
 			return nil
 		}
-		startBlock = lc.LastBlockNumber + 1
-		fmt.Printf("Last check at %v\nStarting at block %v\n", lc.LastCheckAt, startBlock)
+		fmt.Printf("No blocks before %v, retrying failed pairs...\n", stopAt)
+		endBlock = lc.LastBlockNumber
+		stopAt = lc.LastCheckAt
 	}
-	if startBlock == 0 {
-		return gotils.C(ctx).Errorf("startBlock is zero")
-	}
-
-	fmt.Printf("fetching from block %v to %v\n", startBlock, endBlock)
-
-	db, _ := backend.NewFirestore(ctx, fs)
 
 	// load tokens into the cache
 	_, err = db.GetTokens(ctx)
@@ -247,262 +287,494 @@ func FetchData(ctx context.Context, rpc *goclient.Client, fs *firestore.Client)
 	if err != nil {
 		return gotils.C(ctx).Errorf("error on GetPairs: %v", err)
 	}
-	// get any new pairs
-	newPairs, err := GetPairsFromChain(ctx, rpc, len(pairs)) // will return any new pairs
+	// get any new pairs, from the block after the newest one we know about. if any stored
+	// pair is missing its creation info, rescan everything so it gets filled in.
+	pairsFromBlock := FactoryStartBlock
+	for _, pair := range pairs {
+		if pair.CreatedBlock == 0 {
+			pairsFromBlock = FactoryStartBlock
+			break
+		}
+		if pair.CreatedBlock >= pairsFromBlock {
+			pairsFromBlock = pair.CreatedBlock + 1
+		}
+	}
+	newPairs, err := GetPairsFromChain(ctx, rpc, pairsFromBlock, endBlock) // will return any new pairs
 	if err != nil {
 		return gotils.C(ctx).Errorf("error on GetAllPairs: %v", err)
 	}
 	// store any new pairs
-	err = storePairs(ctx, rpc, fs, newPairs)
+	err = storePairs(ctx, db, newPairs)
 	if err != nil {
 		return gotils.C(ctx).Errorf("error on storePairs: %v", err)
 	}
-	pairs = append(pairs, newPairs...)
-
-	pairMap := map[string]*models.Pair{}
-	tokenMap := map[string]*models.Token{} // cache to add to pairs
-	// set USDCPairs for prices
+	known := map[common.Address]*models.Pair{}
 	for _, pair := range pairs {
-		// fill in tokens
-		if tokenMap[pair.Token0Address] != nil {
-			pair.Token0 = tokenMap[pair.Token0Address]
+		known[pair.Address] = pair
+	}
+	for _, pair := range newPairs {
+		if known[pair.Address] == nil {
+			pairs = append(pairs, pair)
 		} else {
-			pair.Token0, err = db.GetToken(ctx, pair.Token0Address)
-			if err != nil {
-				return gotils.C(ctx).Errorf("%v", err)
-			}
-			tokenMap[pair.Token0Address] = pair.Token0
+			known[pair.Address].CreatedBlock = pair.CreatedBlock
 		}
-		if tokenMap[pair.Token1Address] != nil {
-			pair.Token1 = tokenMap[pair.Token1Address]
-		} else {
-			pair.Token1, err = db.GetToken(ctx, pair.Token1Address)
+	}
+
+	pairMap, err := setupPairs(ctx, rpc, db, pairs)
+	if err != nil {
+		return gotils.C(ctx).Errorf("error on setupPairs: %v", err)
+	}
+
+	// hours that got new pair buckets, tokens and totals get recomputed for these below
+	touched := map[int64]bool{}
+	var failed []string
+	for _, p := range pairs {
+		pc := pairChecks[p.Address.Hex()]
+		if pc == nil {
+			// new pair, backfill from when it was created. pairs that the global cursor already
+			// covered (from before pairs had their own checks) pick up where it left off.
+			pc = &models.PairCheck{Address: p.Address.Hex(), LastBlockNumber: p.CreatedBlock - 1}
+			if p.CreatedBlock == 0 || (lc.LastBlockNumber > 0 && p.CreatedBlock <= lc.LastBlockNumber) {
+				pc.LastBlockNumber = lc.LastBlockNumber
+				pc.LastCheckAt = lc.LastCheckAt
+				if pc.LastBlockNumber == 0 {
+					pc.LastBlockNumber = endBlock - (720 * 2) // about 2 hours
+				}
+			}
+			// save it now so a failure below doesn't lose where this pair starts
+			err = db.SavePairCheck(ctx, pc)
 			if err != nil {
-				return gotils.C(ctx).Errorf("%v", err)
+				return gotils.C(ctx).Errorf("error saving pair check: %v", err)
 			}
-			tokenMap[pair.Token1Address] = pair.Token1
 		}
-		pc, err := contracts.NewPair(pair.Address, rpc)
+
+		if !pc.LastCheckAt.Before(stopAt) || pc.LastBlockNumber >= endBlock {
+			fmt.Printf("%v is up to date\n", p.String())
+			continue
+		}
+		startBlock := pc.LastBlockNumber + 1
+		fmt.Printf("%v fetching from block %v to %v\n", p.String(), startBlock, endBlock)
+
+		pairBuckets, swaps, liquidityEvents, err := collectPair(ctx, rpc, p, startBlock, endBlock, stopAt, truncateBy)
 		if err != nil {
-			return gotils.C(ctx).Errorf("error on contracts.NewPair: %v", err)
+			gotils.C(ctx).Printf("error collecting %v, will retry next run: %v", p.String(), err)
+			failed = append(failed, p.String())
+			continue
 		}
-		pair.PairContract = pc
-		if pair.Token0.Symbol == "USDC" {
-			USDCPairs[pair.Token1.Symbol] = pair
-			p, err := pair.PriceInUSD(ctx)
-			if err != nil {
-				return gotils.C(ctx).Errorf("error getting price: %v", err)
-			}
-			fmt.Printf("Current price of %v: %v\n", pair.Token1.Symbol, p)
+		// this replaces any partial or reorged events from where the pair left off
+		err = replaceEvents(ctx, db, p, pc.LastCheckAt, stopAt, swaps, liquidityEvents)
+		if err != nil {
+			return err
 		}
-		if pair.Token1.Symbol == "USDC" {
-			USDCPairs[pair.Token0.Symbol] = pair
-			p, err := pair.PriceInUSD(ctx)
+
+		vol := decimal.Zero
+		for t, pb := range pairBuckets {
+			err = db.SavePairBucket(ctx, pb)
 			if err != nil {
-				return gotils.C(ctx).Errorf("error getting price: %v", err)
+				return gotils.C(ctx).Errorf("error on SavePairBucket: %v", err)
 			}
-			fmt.Printf("Current price of %v: %v\n", pair.Token0.Symbol, p)
+			touched[t] = true
+			vol = vol.Add(pb.VolumeUSD)
 		}
-		pairMap[pair.Address.Hex()] = pair
-	}
+		fmt.Printf("%v buckets: %v volume: %v\n", p.String(), len(pairBuckets), vol.StringFixed(2))
 
-	mostRecentBlockProcessed := startBlock
+		// this pair is done, save its progress
+		pc.LastCheckAt = stopAt
+		pc.LastBlockNumber = endBlock
+		err = db.SavePairCheck(ctx, pc)
+		if err != nil {
+			return gotils.C(ctx).Errorf("error saving pair check: %v", err)
+		}
+	}
 
-	totalBuckets := map[int64]*models.TotalBucket{}
-	pairBucketsMap := map[common.Address]map[int64]*models.PairBucket{}
-	tokenBucketsMap := map[common.Address]map[int64]*models.TokenBucket{}
-	pairLiquidities := map[common.Address]*models.PairLiquidity{}
-	totalLiquidityUSD := decimal.Zero
-	for _, p := range pairs {
+	err = rollupBuckets(ctx, db, pairMap, touched)
+	if err != nil {
+		return gotils.C(ctx).Errorf("error on rollupBuckets: %v", err)
+	}
+	from, to := hourSpan(touched)
+	if !rewoundAt.IsZero() {
+		if from.IsZero() || rewoundAt.Before(from) {
+			from = rewoundAt
+		}
+		if to.Before(stopAt) {
+			to = stopAt
+		}
+	}
+	err = rollupPeriods(ctx, db, from, to)
+	if err != nil {
+		return gotils.C(ctx).Errorf("error on rollupPeriods: %v", err)
+	}
 
-		pairLiquidity, err := fetchLiquidity(ctx, rpc, fs, p)
+	// a run that only retried failed pairs is already checkpointed
+	if endBlock > lc.LastBlockNumber {
+		hash, err := GetBlockHashByNumber(ctx, rpc, endBlock)
 		if err != nil {
-			return gotils.C(ctx).Errorf("error on fetchLiquidity: %v", err)
+			return gotils.C(ctx).Errorf("error getting block hash: %v", err)
 		}
-		pairLiquidities[p.Address] = pairLiquidity
-		fmt.Printf("%v liquidity: %v\n", p.String(), pairLiquidity.ValUSD())
-		totalLiquidityUSD = totalLiquidityUSD.Add(pairLiquidity.ValUSD())
-
-		swapEvents, err := GetSwapEvents(ctx, rpc, p.Address, startBlock, endBlock)
+		cp := &models.Checkpoint{CheckAt: stopAt, BlockNumber: endBlock, BlockHash: hash}
+		lc.Checkpoints = append(lc.Checkpoints, cp)
+		if len(lc.Checkpoints) > maxCheckpoints {
+			lc.Checkpoints = lc.Checkpoints[len(lc.Checkpoints)-maxCheckpoints:]
+		}
+		lc.LastCheckAt = cp.CheckAt
+		lc.LastBlockNumber = cp.BlockNumber
+		lc.LastBlockHash = cp.BlockHash
+		err = db.SaveLastCheck(ctx, lc)
 		if err != nil {
-			return gotils.C(ctx).Errorf("error on GetSwapEvents: %v", err)
+			return gotils.C(ctx).Errorf("error on SaveLastCheck: %v", err)
 		}
-		fmt.Printf("%v swap events for %v\n", len(swapEvents), p.String())
+	}
 
-		pairBuckets := pairBucketsMap[p.Address]
-		if pairBuckets == nil {
-			pairBuckets = map[int64]*models.PairBucket{}
-			pairBucketsMap[p.Address] = pairBuckets
+	if len(failed) > 0 {
+		return gotils.C(ctx).Errorf("%v pairs failed and will be retried next run: %v", len(failed), failed)
+	}
+	return nil
+}
+
+// pairsBehind returns whether any pair's check is before blockNumber, ie it failed in an earlier run
+func pairsBehind(pairChecks map[string]*models.PairCheck, blockNumber int64) bool {
+	for _, pc := range pairChecks {
+		if pc.LastBlockNumber < blockNumber {
+			return true
 		}
-		bucketsMade := 0
-		for _, ev := range swapEvents {
-			// Stop processing the last bucket, since it'll most likely be partial
-			if !ev.Timestamp.Before(stopAt) { // using before so it doesn't include if it's equal
-				fmt.Printf("stopping since event is after stopAt")
-				break
-			}
-			// tally up for each pair
-			// tally up for each token
-			// tally up totals across all pools
-			bucketTime := ev.Timestamp.Truncate(truncateBy)
-			// fmt.Printf("bucketTime: %v\n", bucketTime)
-
-			ut := bucketTime.Unix()
-			pairBucket := pairBuckets[ut]
-			if pairBucket == nil {
-				pairBucket = &models.PairBucket{Address: p.Address.Hex(), Pair: p.String(), Time: bucketTime}
-				pairBuckets[ut] = pairBucket
-				pairBucket.Price0USD, err = PriceInUSD(ctx, p.Token0.Symbol)
-				if err != nil {
-					gotils.C(ctx).Printf("error getting price for %v: %v\n", p.Token0.Symbol, err)
-				}
-				pairBucket.Price1USD, err = PriceInUSD(ctx, p.Token1.Symbol)
-				if err != nil {
-					gotils.C(ctx).Printf("error getting price for %v: %v\n", p.Token1.Symbol, err)
-				}
+	}
+	return false
+}
 
-				// liquidity
-				pairBucket.Reserve0 = pairLiquidity.Reserve0
-				pairBucket.Reserve1 = pairLiquidity.Reserve1
-				pairBucket.TotalSupply = pairLiquidity.TotalSupply
+// collectPair gets all the events for a pair between startBlock and endBlock and tallies them up into buckets,
+// it also returns each swap, mint and burn before stopAt
+func collectPair(ctx context.Context, rpc ChainReader, p *models.Pair, startBlock, endBlock int64, stopAt time.Time, truncateBy time.Duration) (map[int64]*models.PairBucket, []*models.Swap, []*models.LiquidityEvent, error) {
+	swapEvents, err := GetSwapEvents(ctx, rpc, p.Address, startBlock, endBlock)
+	if err != nil {
+		return nil, nil, nil, gotils.C(ctx).Errorf("error on GetSwapEvents: %v", err)
+	}
+	fmt.Printf("%v swap events for %v\n", len(swapEvents), p.String())
 
-			}
-			amount0In := utils.IntToDec(ev.Amount0In, p.Token0.Decimals)
-			amount1In := utils.IntToDec(ev.Amount1In, p.Token1.Decimals)
-			amount0Out := utils.IntToDec(ev.Amount0Out, p.Token0.Decimals)
-			amount1Out := utils.IntToDec(ev.Amount1Out, p.Token1.Decimals)
-			pairBucket.Amount0In = pairBucket.Amount0In.Add(amount0In)
-			pairBucket.Amount1In = pairBucket.Amount1In.Add(amount1In)
-			pairBucket.Amount0Out = pairBucket.Amount0Out.Add(amount0Out)
-			pairBucket.Amount1Out = pairBucket.Amount1Out.Add(amount1Out)
-
-			volumeUSD := amount0In.Mul(pairBucket.Price0USD).Add(amount1In.Mul(pairBucket.Price1USD))
-			pairBucket.VolumeUSD = pairBucket.VolumeUSD.Add(volumeUSD)
-			bucketsMade++
-
-			if ev.BlockNumber > mostRecentBlockProcessed {
-				mostRecentBlockProcessed = ev.BlockNumber
-			}
-		}
-		fmt.Printf("%v PairBuckets made: %v\n", p.String(), bucketsMade)
-		if bucketsMade == 0 {
-			// we'll make one for prior hour, just so we get the liquidity right
-			fmt.Printf("Making PairBucket for liquidity\n")
+	burnEvents, err := GetBurnEvents(ctx, rpc, p.Address, startBlock, endBlock)
+	if err != nil {
+		return nil, nil, nil, gotils.C(ctx).Errorf("error on GetBurnEvents: %v", err)
+	}
+	fmt.Printf("%v burn events for %v\n", len(burnEvents), p.String())
 
-			bucketTime := time.Now().Add(-(1 * time.Hour)).Truncate(truncateBy)
-			ut := bucketTime.Unix()
-			pairBucket := &models.PairBucket{Address: p.Address.Hex(), Pair: p.String(), Time: bucketTime}
-			pairBuckets[ut] = pairBucket
-			pairBucket.Price0USD, err = PriceInUSD(ctx, p.Token0.Symbol)
-			if err != nil {
-				gotils.C(ctx).Printf("error getting price for %v: %v\n", p.Token0.Symbol, err)
-			}
-			pairBucket.Price1USD, err = PriceInUSD(ctx, p.Token1.Symbol)
+	mintEvents, err := GetMintEvents(ctx, rpc, p.Address, startBlock, endBlock, uint64(maxBlockPerRequest))
+	if err != nil {
+		return nil, nil, nil, gotils.C(ctx).Errorf("error on GetMintEvents: %v", err)
+	}
+	fmt.Printf("%v mint events for %v\n", len(mintEvents), p.String())
+
+	syncEvents, err := GetSyncEvents(ctx, rpc, p.Address, startBlock, endBlock)
+	if err != nil {
+		return nil, nil, nil, gotils.C(ctx).Errorf("error on GetSyncEvents: %v", err)
+	}
+	fmt.Printf("%v sync events for %v\n", len(syncEvents), p.String())
+
+	// for how many LP tokens each mint and burn was
+	lpTransfers, err := GetLPTransferEvents(ctx, rpc, p.Address, startBlock, endBlock)
+	if err != nil {
+		return nil, nil, nil, gotils.C(ctx).Errorf("error on GetLPTransferEvents: %v", err)
+	}
+
+	// every hour gets a bucket, even without events, so each pair's reserves are there for every hour to
+	// add up the liquidity from
+	startTime, err := GetTimestampByBlockNumber(ctx, rpc, startBlock)
+	if err != nil {
+		return nil, nil, nil, gotils.C(ctx).Errorf("error getting start block time: %v", err)
+	}
+	hours := map[int64]bool{}
+	for h := startTime.Truncate(truncateBy); h.Before(stopAt); h = h.Add(truncateBy) {
+		hours[h.Unix()] = true
+	}
+	var times []time.Time
+	for _, ev := range swapEvents {
+		times = append(times, ev.Timestamp)
+	}
+	for _, ev := range mintEvents {
+		times = append(times, ev.Timestamp)
+	}
+	for _, ev := range burnEvents {
+		times = append(times, ev.Timestamp)
+	}
+	for _, ev := range syncEvents {
+		times = append(times, ev.Timestamp)
+	}
+	for _, ts := range times {
+		if ts.Before(stopAt) {
+			hours[ts.Truncate(truncateBy).Unix()] = true
+		}
+	}
+	if len(hours) == 0 {
+		fmt.Printf("Making PairBucket for liquidity\n")
+		hours[stopAt.Add(-truncateBy).Unix()] = true
+	}
+	lo := p.CreatedBlock
+	if lo < 1 {
+		lo = 1
+	}
+	pairBuckets := map[int64]*models.PairBucket{}
+	for ut := range hours {
+		bucketTime := time.Unix(ut, 0)
+		// the last block in the bucket, everything is read as of then
+		closeBlock, err := lastBlockBefore(ctx, rpc, lo, endBlock, bucketTime.Add(truncateBy))
+		if err != nil {
+			return nil, nil, nil, gotils.C(ctx).Errorf("error finding last block in bucket: %v", err)
+		}
+		pairBucket := newPairBucket(ctx, p, bucketTime, closeBlock)
+		if closeBlock >= lo {
+			err = addTWAP(ctx, rpc, p, pairBucket, lo, closeBlock)
 			if err != nil {
-				gotils.C(ctx).Printf("error getting price for %v: %v\n", p.Token1.Symbol, err)
+				return nil, nil, nil, gotils.C(ctx).Errorf("error on addTWAP: %v", err)
 			}
+		}
+		pairBuckets[ut] = pairBucket
+	}
+	getPairBucket := func(bucketTime time.Time) *models.PairBucket {
+		return pairBuckets[bucketTime.Unix()]
+	}
+	bucketsMade := 0
+	var swaps []*models.Swap
+	var liquidityEvents []*models.LiquidityEvent
+	// the prices seen in each bucket, for its candle
+	points := map[int64][]pricePoint{}
+	for _, ev := range swapEvents {
+		// Stop processing the last bucket, since it'll most likely be partial
+		if !ev.Timestamp.Before(stopAt) { // using before so it doesn't include if it's equal
+			fmt.Printf("stopping since event is after stopAt")
+			break
+		}
+		pairBucket := getPairBucket(ev.Timestamp.Truncate(truncateBy))
+		amount0In := utils.IntToDec(ev.Amount0In, p.Token0.Decimals)
+		amount1In := utils.IntToDec(ev.Amount1In, p.Token1.Decimals)
+		amount0Out := utils.IntToDec(ev.Amount0Out, p.Token0.Decimals)
+		amount1Out := utils.IntToDec(ev.Amount1Out, p.Token1.Decimals)
+		pairBucket.Amount0In = pairBucket.Amount0In.Add(amount0In)
+		pairBucket.Amount1In = pairBucket.Amount1In.Add(amount1In)
+		pairBucket.Amount0Out = pairBucket.Amount0Out.Add(amount0Out)
+		pairBucket.Amount1Out = pairBucket.Amount1Out.Add(amount1Out)
+
+		volumeUSD := amount0In.Mul(pairBucket.Price0USD).Add(amount1In.Mul(pairBucket.Price1USD))
+		pairBucket.VolumeUSD = pairBucket.VolumeUSD.Add(volumeUSD)
+		pairBucket.FeesUSD = pairBucket.FeesUSD.Add(volumeUSD.Mul(FeeRate))
+		pairBucket.SwapCount++
+		pairBucket.AddTraders(ev.TxFrom.Hex())
+		bucketsMade++
+		if price, ok := executionPrice(amount0In, amount1In, amount0Out, amount1Out); ok {
+			ut := pairBucket.Time.Unix()
+			points[ut] = append(points[ut], pricePoint{ev.BlockNumber, ev.LogIndex, price})
+		}
 
-			// liquidity
-			pairBucket.Reserve0 = pairLiquidity.Reserve0
-			pairBucket.Reserve1 = pairLiquidity.Reserve1
-			pairBucket.TotalSupply = pairLiquidity.TotalSupply
+		swaps = append(swaps, &models.Swap{
+			Address:     p.Address.Hex(),
+			Pair:        p.String(),
+			Token0:      p.Token0.Address.Hex(),
+			Token1:      p.Token1.Address.Hex(),
+			TxHash:      ev.TransactionHash,
+			LogIndex:    int64(ev.LogIndex),
+			BlockNumber: ev.BlockNumber,
+			Time:        ev.Timestamp,
+			From:        ev.From.Hex(),
+			To:          ev.To.Hex(),
+			TxFrom:      ev.TxFrom.Hex(),
+			Amount0In:   amount0In,
+			Amount1In:   amount1In,
+			Amount0Out:  amount0Out,
+			Amount1Out:  amount1Out,
+			ValueUSD:    volumeUSD,
+		})
+	}
+	for _, ev := range mintEvents {
+		if !ev.Timestamp.Before(stopAt) {
+			fmt.Printf("stopping since event is after stopAt")
+			break
+		}
+		pairBucket := getPairBucket(ev.Timestamp.Truncate(truncateBy))
+		amount0 := utils.IntToDec(ev.Amount0, p.Token0.Decimals)
+		amount1 := utils.IntToDec(ev.Amount1, p.Token1.Decimals)
+		pairBucket.LiquidityAdded0 = pairBucket.LiquidityAdded0.Add(amount0)
+		pairBucket.LiquidityAdded1 = pairBucket.LiquidityAdded1.Add(amount1)
+		addedUSD := amount0.Mul(pairBucket.Price0USD).Add(amount1.Mul(pairBucket.Price1USD))
+		pairBucket.LiquidityAddedUSD = pairBucket.LiquidityAddedUSD.Add(addedUSD)
+		pairBucket.MintCount++
+		bucketsMade++
+
+		le := newLiquidityEvent(p, models.LiquidityMint, ev.TransactionHash, int64(ev.LogIndex), ev.BlockNumber, ev.Timestamp)
+		le.From = ev.FromContract.Hex()
+		le.TxFrom = ev.TxFrom.Hex()
+		le.Amount0, le.Amount1, le.ValueUSD = amount0, amount1, addedUSD
+		if t, ok := lpMinted(lpTransfers, ev.TransactionHash, ev.LogIndex); ok {
+			le.To = t.To.Hex()
+			le.Liquidity = utils.IntToDec(t.Value, 18)
+		}
+		liquidityEvents = append(liquidityEvents, le)
+	}
+	for _, ev := range burnEvents {
+		if !ev.Timestamp.Before(stopAt) {
+			fmt.Printf("stopping since event is after stopAt")
+			break
+		}
+		pairBucket := getPairBucket(ev.Timestamp.Truncate(truncateBy))
+		amount0 := utils.IntToDec(ev.Amount0, p.Token0.Decimals)
+		amount1 := utils.IntToDec(ev.Amount1, p.Token1.Decimals)
+		pairBucket.LiquidityRemoved0 = pairBucket.LiquidityRemoved0.Add(amount0)
+		pairBucket.LiquidityRemoved1 = pairBucket.LiquidityRemoved1.Add(amount1)
+		removedUSD := amount0.Mul(pairBucket.Price0USD).Add(amount1.Mul(pairBucket.Price1USD))
+		pairBucket.LiquidityRemovedUSD = pairBucket.LiquidityRemovedUSD.Add(removedUSD)
+		bucketsMade++
+
+		le := newLiquidityEvent(p, models.LiquidityBurn, ev.TransactionHash, int64(ev.LogIndex), ev.BlockNumber, ev.Timestamp)
+		le.From = ev.Sender.Hex()
+		le.To = ev.To.Hex()
+		le.TxFrom = ev.TxFrom.Hex()
+		le.Amount0, le.Amount1, le.ValueUSD = amount0, amount1, removedUSD
+		if t, ok := lpBurned(lpTransfers, ev.TransactionHash, ev.LogIndex); ok {
+			le.Liquidity = utils.IntToDec(t.Value, 18)
+		}
+		liquidityEvents = append(liquidityEvents, le)
+	}
+	// reserves at the end of each hour are the last Sync in it, events are in chain order
+	for _, ev := range syncEvents {
+		if !ev.Timestamp.Before(stopAt) {
+			fmt.Printf("stopping since event is after stopAt")
+			break
+		}
+		pairBucket := getPairBucket(ev.Timestamp.Truncate(truncateBy))
+		pairBucket.Reserve0 = utils.IntToDec(ev.Reserve0, p.Token0.Decimals)
+		pairBucket.Reserve1 = utils.IntToDec(ev.Reserve1, p.Token1.Decimals)
+		bucketsMade++
+		if price, ok := spotPrice(pairBucket.Reserve0, pairBucket.Reserve1); ok {
+			ut := pairBucket.Time.Unix()
+			points[ut] = append(points[ut], pricePoint{ev.BlockNumber, ev.LogIndex, price})
 		}
+	}
+	addCandles(pairBuckets, points)
+	fmt.Printf("%v PairBuckets made: %v\n", p.String(), bucketsMade)
+	return pairBuckets, swaps, liquidityEvents, nil
+}
 
-		// fmt.Printf("buckets for %v\n\n", p.String())
-		tokenBuckets0 := tokenBucketsMap[p.Token0.Address]
-		if tokenBuckets0 == nil {
-			tokenBuckets0 = map[int64]*models.TokenBucket{}
-			tokenBucketsMap[p.Token0.Address] = tokenBuckets0
+// rollupBuckets recomputes the token and total buckets for the given hours from all the stored pair
+// buckets in them, so pairs that were collected in different runs all get counted
+func rollupBuckets(ctx context.Context, db backend.Backend, pairMap map[string]*models.Pair, hours map[int64]bool) error {
+	if len(hours) == 0 {
+		return nil
+	}
+	var from, to int64
+	for t := range hours {
+		if from == 0 || t < from {
+			from = t
 		}
-		tokenBuckets1 := tokenBucketsMap[p.Token1.Address]
-		if tokenBuckets1 == nil {
-			tokenBuckets1 = map[int64]*models.TokenBucket{}
-			tokenBucketsMap[p.Token1.Address] = tokenBuckets1
+		if t > to {
+			to = t
 		}
+	}
+	// hourly interval so nothing gets rolled up, the range is exclusive so widen it a bit
+	pbs, err := db.GetPairBuckets(ctx, "", time.Unix(from, 0).Add(-time.Second), time.Unix(to, 0).Add(time.Second), time.Hour)
+	if err != nil {
+		return gotils.C(ctx).Errorf("error on GetPairBuckets: %v", err)
+	}
 
-		for t, v := range pairBuckets {
-			// fmt.Printf("%v %v 0in: %v 1in: %v, 0out: %v 1out: %v\n", t, v.Time, v.Amount0In, v.Amount1In, v.Amount0Out, v.Amount1Out)
-			t2 := time.Unix(t, 0)
-			// fmt.Printf("t2: %v\n", t2)
-			// token0
-			{
-				tokenBucket0 := tokenBuckets0[t]
-				if tokenBucket0 == nil {
-					tokenBucket0 = &models.TokenBucket{Address: p.Token0.Address.Hex(), Symbol: p.Token0.Symbol, Time: t2}
-					tokenBucket0.PriceUSD = v.Price0USD
-					tokenBuckets0[t] = tokenBucket0
-				}
-				tokenBucket0.AmountIn = tokenBucket0.AmountIn.Add(v.Amount0In)
-				tokenBucket0.AmountOut = tokenBucket0.AmountOut.Add(v.Amount0Out)
-				volumeUSD := v.Amount0In.Mul(v.Price0USD)
-				tokenBucket0.VolumeUSD = tokenBucket0.VolumeUSD.Add(volumeUSD)
+	totalBuckets := map[int64]*models.TotalBucket{}
+	tokenBucketsMap := map[common.Address]map[int64]*models.TokenBucket{}
+	// a token's candle comes from its most liquid pair with one, this is how liquid that was
+	candleLiquidity := map[*models.TokenBucket]decimal.Decimal{}
+	setCandle := func(tb *models.TokenBucket, c models.OHLC, liquidityUSD decimal.Decimal) {
+		if c.IsZero() || (!tb.OHLCUSD.IsZero() && !liquidityUSD.GreaterThan(candleLiquidity[tb])) {
+			return
+		}
+		tb.OHLCUSD = c
+		candleLiquidity[tb] = liquidityUSD
+	}
+	for _, v := range pbs {
+		t := v.Time.Unix()
+		p := pairMap[v.Address]
+		if !hours[t] || p == nil {
+			continue
+		}
+		t2 := time.Unix(t, 0)
+
+		// token0
+		{
+			tokenBuckets0 := tokenBucketsMap[p.Token0.Address]
+			if tokenBuckets0 == nil {
+				tokenBuckets0 = map[int64]*models.TokenBucket{}
+				tokenBucketsMap[p.Token0.Address] = tokenBuckets0
 			}
-
-			// token1
-			{
-				tokenBucket1 := tokenBuckets1[t]
-				if tokenBucket1 == nil {
-					tokenBucket1 = &models.TokenBucket{Address: p.Token1.Address.Hex(), Symbol: p.Token1.Symbol, Time: t2}
-					tokenBucket1.PriceUSD = v.Price1USD
-					tokenBuckets1[t] = tokenBucket1
-				}
-				tokenBucket1.AmountIn = tokenBucket1.AmountIn.Add(v.Amount1In)
-				tokenBucket1.AmountOut = tokenBucket1.AmountOut.Add(v.Amount1Out)
-				volumeUSD := v.Amount1In.Mul(v.Price1USD)
-				tokenBucket1.VolumeUSD = tokenBucket1.VolumeUSD.Add(volumeUSD)
+			tokenBucket0 := tokenBuckets0[t]
+			if tokenBucket0 == nil {
+				tokenBucket0 = &models.TokenBucket{Address: p.Token0.Address.Hex(), Symbol: p.Token0.Symbol, Time: t2}
+				tokenBucket0.PriceUSD = v.Price0USD
+				tokenBuckets0[t] = tokenBucket0
 			}
+			tokenBucket0.AmountIn = tokenBucket0.AmountIn.Add(v.Amount0In)
+			tokenBucket0.AmountOut = tokenBucket0.AmountOut.Add(v.Amount0Out)
+			volumeUSD := v.Amount0In.Mul(v.Price0USD)
+			tokenBucket0.VolumeUSD = tokenBucket0.VolumeUSD.Add(volumeUSD)
+			tokenBucket0.FeesUSD = tokenBucket0.FeesUSD.Add(volumeUSD.Mul(FeeRate))
+			tokenBucket0.SwapCount += v.SwapCount
+			tokenBucket0.AddTraders(v.Traders...)
+			tokenBucket0.LiquidityAdded = tokenBucket0.LiquidityAdded.Add(v.LiquidityAdded0)
+			tokenBucket0.LiquidityAddedUSD = tokenBucket0.LiquidityAddedUSD.Add(v.LiquidityAdded0.Mul(v.Price0USD))
+			tokenBucket0.MintCount += v.MintCount
+			tokenBucket0.LiquidityRemoved = tokenBucket0.LiquidityRemoved.Add(v.LiquidityRemoved0)
+			tokenBucket0.LiquidityRemovedUSD = tokenBucket0.LiquidityRemovedUSD.Add(v.LiquidityRemoved0.Mul(v.Price0USD))
+			tokenBucket0.Reserve = tokenBucket0.Reserve.Add(v.Reserve0)
+			setCandle(tokenBucket0, tokenCandle(v, true), v.ValUSD())
+		}
 
-			// totals
-			totalBucket := totalBuckets[t]
-			if totalBucket == nil {
-				totalBucket = &models.TotalBucket{Time: t2}
-				totalBuckets[t] = totalBucket
+		// token1
+		{
+			tokenBuckets1 := tokenBucketsMap[p.Token1.Address]
+			if tokenBuckets1 == nil {
+				tokenBuckets1 = map[int64]*models.TokenBucket{}
+				tokenBucketsMap[p.Token1.Address] = tokenBuckets1
 			}
-			totalBucket.VolumeUSD = totalBucket.VolumeUSD.Add(v.VolumeUSD)
-		}
-		for _, tb := range tokenBuckets0 {
-			tb.Reserve = tb.Reserve.Add(pairLiquidity.Reserve0)
-		}
-		for _, tb := range tokenBuckets1 {
-			tb.Reserve = tb.Reserve.Add(pairLiquidity.Reserve1)
+			tokenBucket1 := tokenBuckets1[t]
+			if tokenBucket1 == nil {
+				tokenBucket1 = &models.TokenBucket{Address: p.Token1.Address.Hex(), Symbol: p.Token1.Symbol, Time: t2}
+				tokenBucket1.PriceUSD = v.Price1USD
+				tokenBuckets1[t] = tokenBucket1
+			}
+			tokenBucket1.AmountIn = tokenBucket1.AmountIn.Add(v.Amount1In)
+			tokenBucket1.AmountOut = tokenBucket1.AmountOut.Add(v.Amount1Out)
+			volumeUSD := v.Amount1In.Mul(v.Price1USD)
+			tokenBucket1.VolumeUSD = tokenBucket1.VolumeUSD.Add(volumeUSD)
+			tokenBucket1.FeesUSD = tokenBucket1.FeesUSD.Add(volumeUSD.Mul(FeeRate))
+			tokenBucket1.SwapCount += v.SwapCount
+			tokenBucket1.AddTraders(v.Traders...)
+			tokenBucket1.LiquidityAdded = tokenBucket1.LiquidityAdded.Add(v.LiquidityAdded1)
+			tokenBucket1.LiquidityAddedUSD = tokenBucket1.LiquidityAddedUSD.Add(v.LiquidityAdded1.Mul(v.Price1USD))
+			tokenBucket1.MintCount += v.MintCount
+			tokenBucket1.LiquidityRemoved = tokenBucket1.LiquidityRemoved.Add(v.LiquidityRemoved1)
+			tokenBucket1.LiquidityRemovedUSD = tokenBucket1.LiquidityRemovedUSD.Add(v.LiquidityRemoved1.Mul(v.Price1USD))
+			tokenBucket1.Reserve = tokenBucket1.Reserve.Add(v.Reserve1)
+			setCandle(tokenBucket1, tokenCandle(v, false), v.ValUSD())
 		}
 
-	}
-
-	// TODO: store all data in db here
-	fmt.Printf("\nSTORE PAIR DATA:\n\n")
-	v := decimal.Zero
-	for pairAddress, pbs := range pairBucketsMap {
-		fmt.Printf("Pair: %s\n", pairAddress.Hex())
-		vol := decimal.Zero
-		for t, pb := range pbs {
-			t2 := time.Unix(t, 0)
-			fmt.Printf("time bucket: %v -- %v\n", t, t2)
-			pb.PreSave()
-			_, err = fs.Collection(backend.CollectionPairBuckets).Doc(fmt.Sprintf("%v_%v", pairAddress.Hex(), t)).Set(ctx, pb)
-			if err != nil {
-				return gotils.C(ctx).Errorf("error writing to db: %v", err)
-			}
-			vol = vol.Add(pb.VolumeUSD)
+		// totals
+		totalBucket := totalBuckets[t]
+		if totalBucket == nil {
+			totalBucket = &models.TotalBucket{Time: t2}
+			totalBuckets[t] = totalBucket
 		}
-		v = v.Add(vol)
-		fmt.Printf("Volume: %v\n", vol.StringFixed(2))
+		totalBucket.VolumeUSD = totalBucket.VolumeUSD.Add(v.VolumeUSD)
+		totalBucket.FeesUSD = totalBucket.FeesUSD.Add(v.FeesUSD)
+		totalBucket.SwapCount += v.SwapCount
+		totalBucket.AddTraders(v.Traders...)
+		// every pair has a bucket every hour, so this adds up to all the liquidity as of then
+		totalBucket.LiquidityUSD = totalBucket.LiquidityUSD.Add(v.ValUSD())
 	}
-	fmt.Printf("total: %v\n", v)
 
 	fmt.Printf("\nSTORE TOKEN DATA:\n\n")
-	v = decimal.Zero
+	v := decimal.Zero
 	for address, pbs := range tokenBucketsMap {
 		fmt.Printf("Token: %v\n", address.Hex())
 		vol := decimal.Zero
 		for t, pb := range pbs {
+			pb.LiquidityUSD = pb.Reserve.Mul(pb.PriceUSD)
 			vol = vol.Add(pb.VolumeUSD)
 			t2 := time.Unix(t, 0)
 			fmt.Printf("time bucket: %v -- %v\n", t, t2)
-			pb.PreSave()
-			_, err = fs.Collection(backend.CollectionTokenBuckets).Doc(fmt.Sprintf("%v_%v", address.Hex(), t)).Set(ctx, pb)
+			err := db.SaveTokenBucket(ctx, pb)
 			if err != nil {
-				return gotils.C(ctx).Errorf("error writing to db: %v", err)
+				return gotils.C(ctx).Errorf("error on SaveTokenBucket: %v", err)
 			}
 		}
 		v = v.Add(vol)
@@ -513,93 +785,165 @@ func FetchData(ctx context.Context, rpc *goclient.Client, fs *firestore.Client)
 	{
 		fmt.Printf("\nTOTALS:\n\n")
 		vol := decimal.Zero
-		for t, pb := range totalBuckets {
-			pb.LiquidityUSD = totalLiquidityUSD
-			pb.PreSave()
-			_, err = fs.Collection(backend.CollectionTotals).Doc(fmt.Sprintf("%v", t)).Set(ctx, pb)
+		for _, pb := range totalBuckets {
+			err := db.SaveTotalBucket(ctx, pb)
 			if err != nil {
-				return gotils.C(ctx).Errorf("error writing to db: %v", err)
+				return gotils.C(ctx).Errorf("error on SaveTotalBucket: %v", err)
 			}
 			vol = vol.Add(pb.VolumeUSD)
+			fmt.Printf("%v liquidity: %v\n", pb.Time, pb.LiquidityUSD.StringFixed(2))
 		}
-		fmt.Printf("Volume: %v liquidity: %v\n", vol.StringFixed(2), totalLiquidityUSD.StringFixed(2))
+		fmt.Printf("Volume: %v\n", vol.StringFixed(2))
 	}
+	return nil
+}
 
-	// TODO: save last_check
-	lc = &LastCheck{
-		LastCheckAt:     stopAt, // setting this so it will
-		LastBlockNumber: mostRecentBlockProcessed,
+// setupPairs fills in the tokens and contracts for the pairs and builds the price graph from them.
+// Returns the pairs keyed by address.
+func setupPairs(ctx context.Context, rpc ChainReader, db backend.Backend, pairs []*models.Pair) (map[string]*models.Pair, error) {
+	var err error
+	pairMap := map[string]*models.Pair{}
+	tokenMap := map[string]*models.Token{} // cache to add to pairs
+	for _, pair := range pairs {
+		// fill in tokens
+		if tokenMap[pair.Token0Address] != nil {
+			pair.Token0 = tokenMap[pair.Token0Address]
+		} else {
+			pair.Token0, err = db.GetToken(ctx, pair.Token0Address)
+			if err != nil {
+				return nil, gotils.C(ctx).Errorf("%v", err)
+			}
+			tokenMap[pair.Token0Address] = pair.Token0
+		}
+		if tokenMap[pair.Token1Address] != nil {
+			pair.Token1 = tokenMap[pair.Token1Address]
+		} else {
+			pair.Token1, err = db.GetToken(ctx, pair.Token1Address)
+			if err != nil {
+				return nil, gotils.C(ctx).Errorf("%v", err)
+			}
+			tokenMap[pair.Token1Address] = pair.Token1
+		}
+		pc, err := contracts.NewPair(pair.Address, contractBackend(rpc))
+		if err != nil {
+			return nil, gotils.C(ctx).Errorf("error on contracts.NewPair: %v", err)
+		}
+		pair.PairContract = pc
+		if StablecoinAddresses[pair.Token0.Address] {
+			USDCPairs[pair.Token1.Address.Hex()] = pair
+		}
+		if StablecoinAddresses[pair.Token1.Address] {
+			USDCPairs[pair.Token0.Address.Hex()] = pair
+		}
+		pairMap[pair.Address.Hex()] = pair
 	}
-	lcRef.Set(ctx, lc)
-	return nil
+	mu.Lock()
+	for address, t := range tokenMap {
+		TokenMap[address] = t
+	}
+	mu.Unlock()
+	warnSymbolCollisions()
+	err = updatePrices(ctx, pairs)
+	if err != nil {
+		return nil, gotils.C(ctx).Errorf("error on updatePrices: %v", err)
+	}
+	return pairMap, nil
+}
 
+// warnSymbolCollisions logs any tokens in TokenMap that share a symbol
+func warnSymbolCollisions() {
+	mu.RLock()
+	tokens := make([]*models.Token, 0, len(TokenMap))
+	for _, t := range TokenMap {
+		tokens = append(tokens, t)
+	}
+	mu.RUnlock()
+	for symbol, addresses := range models.SymbolCollisions(tokens) {
+		fmt.Printf("WARN: %v tokens share the symbol %v: %v\n", len(addresses), symbol, addresses)
+	}
+}
+
+// newLiquidityEvent returns a mint or burn on p, the rest gets filled in from the event
+func newLiquidityEvent(p *models.Pair, typ, txHash string, logIndex, blockNumber int64, t time.Time) *models.LiquidityEvent {
+	return &models.LiquidityEvent{
+		Type:        typ,
+		Address:     p.Address.Hex(),
+		Pair:        p.String(),
+		Token0:      p.Token0.Address.Hex(),
+		Token1:      p.Token1.Address.Hex(),
+		TxHash:      txHash,
+		LogIndex:    logIndex,
+		BlockNumber: blockNumber,
+		Time:        t,
+	}
 }
 
-func fetchLiquidity(ctx context.Context, rpc *goclient.Client, fs *firestore.Client, pair *models.Pair) (*models.PairLiquidity, error) {
-	t0 := pair.Token0
-	price0, err := PriceInUSD(ctx, t0.Symbol)
+// replaceEvents stores the pair's swaps, mints and burns at or after from and before to in place of
+// any that were there
+func replaceEvents(ctx context.Context, db backend.Backend, p *models.Pair, from, to time.Time, swaps []*models.Swap, liquidityEvents []*models.LiquidityEvent) error {
+	err := db.ReplaceSwaps(ctx, p.Address.Hex(), from, to, swaps)
 	if err != nil {
-		var e *models.PriceNotFound
-		if errors.As(err, &e) {
-			// err is a *QueryError, and e is set to the error's value
-			fmt.Printf("%v price error: %v\n", t0.Symbol, e)
-		} else {
-			return nil, gotils.C(ctx).Errorf("error getting price0 for %v: %v", t0.Symbol, err)
-		}
+		return gotils.C(ctx).Errorf("error on ReplaceSwaps: %v", err)
 	}
-	t1 := pair.Token1
-	price1, err := PriceInUSD(ctx, t1.Symbol)
+	err = db.ReplaceLiquidityEvents(ctx, p.Address.Hex(), from, to, liquidityEvents)
 	if err != nil {
-		var e *models.PriceNotFound
-		if errors.As(err, &e) {
-			// err is a *QueryError, and e is set to the error's value
-			fmt.Printf("%v price error: %v\n", t0.Symbol, e)
-		} else {
-			return nil, gotils.C(ctx).Errorf("error getting price1 for %v: %v", t1.Symbol, err)
-		}
+		return gotils.C(ctx).Errorf("error on ReplaceLiquidityEvents: %v", err)
 	}
-	reserve0, reserve1, err := pair.GetReserves(ctx)
+	return nil
+}
+
+// newPairBucket makes an empty bucket for the pair with the prices, reserves and supply as of closeBlock,
+// the last block in it. Reading old blocks needs an archive node, if that fails they're read as of now.
+func newPairBucket(ctx context.Context, p *models.Pair, bucketTime time.Time, closeBlock int64) *models.PairBucket {
+	var err error
+	pairBucket := &models.PairBucket{Address: p.Address.Hex(), Pair: p.String(), Time: bucketTime}
+	if closeBlock < p.CreatedBlock {
+		// pair didn't exist yet
+		return pairBucket
+	}
+	prices := pricesAt(ctx, closeBlock)
+	pairBucket.Price0USD, err = prices.price(p.Token0.Address)
 	if err != nil {
-		return nil, gotils.C(ctx).Errorf("error on GetReserves: %v", err)
+		gotils.C(ctx).Printf("error getting price for %v: %v\n", p.Token0.Symbol, err)
 	}
-	totalSupplyBig, err := pair.PairContract.TotalSupply(nil)
+	pairBucket.Price1USD, err = prices.price(p.Token1.Address)
 	if err != nil {
-		return nil, gotils.C(ctx).Errorf("error on TotalSupply: %v", err)
+		gotils.C(ctx).Printf("error getting price for %v: %v\n", p.Token1.Symbol, err)
 	}
-	totalSupply := utils.IntToDec(totalSupplyBig, 18)
 
-	poolVal := &models.PairLiquidity{
-		Address: pair.Address.Hex(),
-		Pair:    pair.String(),
-		// Token0:      pair.Token0.Symbol,
-		// Token1:      pair.Token1.Symbol,
-		TotalSupply: totalSupply,
-		Reserve0:    reserve0,
-		Reserve1:    reserve1,
-		Price0USD:   price0,
-		Price1USD:   price1,
+	// reserves get overwritten by any Sync events in the bucket, they're the same on an archive node
+	pairBucket.Reserve0, pairBucket.Reserve1, err = p.GetReserves(ctx, closeBlock)
+	if err != nil {
+		fmt.Printf("WARN: can't read %v reserves at block %v, using current: %v\n", p.String(), closeBlock, err)
+		pairBucket.Reserve0, pairBucket.Reserve1, err = p.GetReserves(ctx, 0)
+		if err != nil {
+			gotils.C(ctx).Printf("error getting reserves for %v: %v\n", p.String(), err)
+		}
+	}
+	pairBucket.TotalSupply, err = p.GetTotalSupply(ctx, closeBlock)
+	if err != nil {
+		fmt.Printf("WARN: can't read %v total supply at block %v, using current: %v\n", p.String(), closeBlock, err)
+		pairBucket.TotalSupply, err = p.GetTotalSupply(ctx, 0)
+		if err != nil {
+			gotils.C(ctx).Printf("error getting total supply for %v: %v\n", p.String(), err)
+		}
 	}
-	return poolVal, nil
+	return pairBucket
 }
 
-func storePairs(ctx context.Context, rpc *goclient.Client, fs *firestore.Client, pairs []*models.Pair) error {
+func storePairs(ctx context.Context, db backend.Backend, pairs []*models.Pair) error {
 	for _, p := range pairs {
 		fmt.Printf("Storing new pair: %v, index: %v\n", p.String(), p.Index)
 		// store tokens too while we're at it
-		t := p.Token0
-		t.PreSave()
-		_, err := fs.Collection(backend.CollectionTokens).Doc(t.Address.Hex()).Set(ctx, t)
+		err := db.SaveToken(ctx, p.Token0)
 		if err != nil {
 			return gotils.C(ctx).Errorf("error storing pair: %v", err)
 		}
-		t = p.Token1
-		t.PreSave()
-		_, err = fs.Collection(backend.CollectionTokens).Doc(t.Address.Hex()).Set(ctx, t)
+		err = db.SaveToken(ctx, p.Token1)
 		if err != nil {
 			return gotils.C(ctx).Errorf("error storing pair: %v", err)
 		}
-		p.PreSave()
-		_, err = fs.Collection(backend.CollectionPairs).Doc(p.Address.Hex()).Set(ctx, p)
+		err = db.SavePair(ctx, p)
 		if err != nil {
 			return gotils.C(ctx).Errorf("error storing pair: %v", err)
 		}
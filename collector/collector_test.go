@@ -0,0 +1,666 @@
+package collector
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/gochain/gochain/v4"
+	"github.com/gochain/gochain/v4/common"
+	"github.com/gochain/gochain/v4/core/types"
+	"github.com/goswap/stats-api/backend"
+	"github.com/goswap/stats-api/collector/fakechain"
+	"github.com/goswap/stats-api/models"
+	"github.com/shopspring/decimal"
+)
+
+var (
+	testStart     = time.Date(2021, 5, 1, 0, 0, 0, 0, time.UTC)
+	testBlockTime = 5 * time.Second
+	testUser      = common.HexToAddress("0x1111111111111111111111111111111111111111")
+)
+
+// amount returns n whole tokens with the given decimals
+func amount(n int64, decimals int) *big.Int {
+	return new(big.Int).Mul(big.NewInt(n), new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(decimals)), nil))
+}
+
+// setupChain makes a chain with a FAST-USDC pair that gets liquidity and a swap in the first hour
+// and a burn in the second, mined to just past the second hour
+func setupChain(t *testing.T) (*fakechain.Chain, common.Address, common.Address) {
+	// collector state is global, start fresh
+	TokenMap = map[string]*models.Token{}
+	USDCPairs = map[string]*models.Pair{}
+	depth, stables := ConfirmationDepth, StablecoinAddresses
+	ConfirmationDepth = 0
+	t.Cleanup(func() { ConfirmationDepth, StablecoinAddresses = depth, stables })
+
+	chain := fakechain.New(common.HexToAddress(FactoryAddress), testStart, testBlockTime)
+	fast := chain.DeployToken("Fast", "FAST", 18)
+	usdc := chain.DeployToken("USD Coin", "USDC", 6)
+	StablecoinAddresses = map[common.Address]bool{usdc: true}
+	chain.Mine(1)
+	pair := chain.DeployPair(fast, usdc)
+	chain.Mine(1)
+	chain.Mint(pair, testUser, amount(1000, 18), amount(2000, 6), amount(100, 18))
+	chain.Mine(10)
+	chain.Swap(pair, testUser, amount(10, 18), big.NewInt(0), big.NewInt(0), amount(19, 6))
+	chain.MineUntil(testStart.Add(65 * time.Minute))
+	chain.Burn(pair, testUser, amount(100, 18), amount(198, 6), amount(10, 18))
+	chain.MineUntil(testStart.Add(130 * time.Minute))
+	return chain, pair, fast
+}
+
+func dec(s string) decimal.Decimal {
+	return decimal.RequireFromString(s)
+}
+
+// pairBuckets returns the stored hourly buckets for the pair by hour since testStart
+func pairBuckets(t *testing.T, db backend.Backend, pair common.Address) map[int]*models.PairBucket {
+	pbs, err := db.GetPairBuckets(context.Background(), pair.Hex(), testStart, testStart.Add(24*time.Hour), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := map[int]*models.PairBucket{}
+	for _, pb := range pbs {
+		m[int(pb.Time.Sub(testStart)/time.Hour)] = pb
+	}
+	return m
+}
+
+func TestFetchData(t *testing.T) {
+	ctx := context.Background()
+	chain, pair, fast := setupChain(t)
+	db := backend.NewMock()
+
+	err := FetchData(ctx, chain, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := db.GetPair(ctx, pair.Hex())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.CreatedBlock != 1 || !p.CreatedAt.Equal(testStart.Add(testBlockTime)) {
+		t.Errorf("expected pair created at block 1, got %v at %v", p.CreatedBlock, p.CreatedAt)
+	}
+
+	price, err := PriceInUSD(ctx, fast)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !price.Equal(dec("1783").Div(dec("910"))) {
+		t.Errorf("expected current FAST price from the latest reserves, got %v", price)
+	}
+	// buckets are priced as of their last block, not now
+	price0 := dec("1981").Div(dec("1010"))
+	price1 := dec("1783").Div(dec("910"))
+	pbs := pairBuckets(t, db, pair)
+	if len(pbs) != 2 {
+		t.Fatalf("expected 2 pair buckets, got %v", len(pbs))
+	}
+	tests := []struct {
+		pb *models.PairBucket
+
+		amount0In, amount1Out         decimal.Decimal
+		added0, added1, removed0      decimal.Decimal
+		mintCount                     int
+		reserve0, reserve1, volumeUSD decimal.Decimal
+		price0USD, totalSupply        decimal.Decimal
+	}{
+		{pbs[0], dec("10"), dec("19"), dec("1000"), dec("2000"), dec("0"), 1, dec("1010"), dec("1981"), price0.Mul(dec("10")), price0, dec("100")},
+		{pbs[1], dec("0"), dec("0"), dec("0"), dec("0"), dec("100"), 0, dec("910"), dec("1783"), dec("0"), price1, dec("90")},
+	}
+	for i, test := range tests {
+		pb := test.pb
+		if pb == nil {
+			t.Fatalf("test %v | missing bucket", i)
+		}
+		if !pb.Amount0In.Equal(test.amount0In) || !pb.Amount1Out.Equal(test.amount1Out) {
+			t.Errorf("test %v | expected swap amounts %v %v, got %v %v", i, test.amount0In, test.amount1Out, pb.Amount0In, pb.Amount1Out)
+		}
+		if !pb.LiquidityAdded0.Equal(test.added0) || !pb.LiquidityAdded1.Equal(test.added1) || pb.MintCount != test.mintCount {
+			t.Errorf("test %v | expected %v mints adding %v %v, got %v adding %v %v", i, test.mintCount, test.added0, test.added1, pb.MintCount, pb.LiquidityAdded0, pb.LiquidityAdded1)
+		}
+		if !pb.LiquidityRemoved0.Equal(test.removed0) {
+			t.Errorf("test %v | expected %v removed, got %v", i, test.removed0, pb.LiquidityRemoved0)
+		}
+		if !pb.Reserve0.Equal(test.reserve0) || !pb.Reserve1.Equal(test.reserve1) {
+			t.Errorf("test %v | expected reserves %v %v, got %v %v", i, test.reserve0, test.reserve1, pb.Reserve0, pb.Reserve1)
+		}
+		if !pb.VolumeUSD.Equal(test.volumeUSD) {
+			t.Errorf("test %v | expected volume %v, got %v", i, test.volumeUSD, pb.VolumeUSD)
+		}
+		if !pb.Price0USD.Equal(test.price0USD) || !pb.TotalSupply.Equal(test.totalSupply) {
+			t.Errorf("test %v | expected price %v and supply %v at the end of the hour, got %v %v", i, test.price0USD, test.totalSupply, pb.Price0USD, pb.TotalSupply)
+		}
+	}
+
+	tbs, err := db.GetTokenBuckets(ctx, "", testStart, testStart.Add(24*time.Hour), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tbs) != 4 {
+		t.Errorf("expected a token bucket per token per hour, got %v", len(tbs))
+	}
+	totals, err := db.GetTotals(ctx, testStart, testStart.Add(24*time.Hour), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(totals) != 2 || !totals[0].VolumeUSD.Equal(price0.Mul(dec("10"))) {
+		t.Errorf("expected 2 totals with the swap volume in the first, got %+v", totals)
+	}
+	// LPs get 0.3% of what went in
+	fees := price0.Mul(dec("10")).Mul(dec("0.003"))
+	if !pbs[0].FeesUSD.Equal(fees) || !totals[0].FeesUSD.Equal(fees) || !pbs[1].FeesUSD.IsZero() {
+		t.Errorf("expected fees of %v in the first hour, got %v %v", fees, pbs[0].FeesUSD, totals[0].FeesUSD)
+	}
+	for _, tb := range tbs {
+		if tb.Address == fast.Hex() && tb.Time.Equal(testStart) && !tb.FeesUSD.Equal(fees) {
+			t.Errorf("expected the fees paid in FAST, got %v", tb.FeesUSD)
+		}
+	}
+	swaps, _, err := db.GetSwaps(ctx, &backend.SwapFilter{Wallet: testUser.Hex()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(swaps) != 1 {
+		t.Fatalf("expected the swap to be stored, got %v", len(swaps))
+	}
+	if s := swaps[0]; s.Address != pair.Hex() || s.Token0 != fast.Hex() || !s.Amount0In.Equal(dec("10")) || !s.Amount1Out.Equal(dec("19")) ||
+		!s.ValueUSD.Equal(price0.Mul(dec("10"))) || s.TxHash == "" || s.Time.IsZero() {
+		t.Errorf("expected the swap with its value at the hour's prices, got %+v", s)
+	}
+	les, _, err := db.GetLiquidityEvents(ctx, &backend.LiquidityEventFilter{Wallet: testUser.Hex()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(les) != 2 {
+		t.Fatalf("expected the mint and burn to be stored, got %v", len(les))
+	}
+	if e := les[0]; e.Type != models.LiquidityBurn || !e.Liquidity.Equal(dec("10")) || !e.Amount0.Equal(dec("100")) || e.TxFrom != testUser.Hex() {
+		t.Errorf("expected the burn of 10 LP tokens first, got %+v", e)
+	}
+	if e := les[1]; e.Type != models.LiquidityMint || !e.Liquidity.Equal(dec("100")) || !e.Amount1.Equal(dec("2000")) || e.To != testUser.Hex() || !e.ValueUSD.IsPositive() {
+		t.Errorf("expected the mint of 100 LP tokens, got %+v", e)
+	}
+
+	pcs, err := db.GetPairChecks(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pc := pcs[pair.Hex()]
+	if pc == nil || !pc.LastCheckAt.Equal(testStart.Add(2*time.Hour)) {
+		t.Fatalf("expected pair check at %v, got %+v", testStart.Add(2*time.Hour), pc)
+	}
+
+	// the next run only picks up the new hour
+	chain.MineUntil(testStart.Add(150 * time.Minute))
+	chain.Swap(pair, testUser, big.NewInt(0), amount(20, 6), amount(9, 18), big.NewInt(0))
+	chain.MineUntil(testStart.Add(190 * time.Minute))
+	err = FetchData(ctx, chain, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pbs = pairBuckets(t, db, pair)
+	if len(pbs) != 3 || pbs[2] == nil {
+		t.Fatalf("expected 3 pair buckets, got %v", len(pbs))
+	}
+	if !pbs[2].Amount0Out.Equal(dec("9")) || !pbs[2].Amount1In.Equal(dec("20")) {
+		t.Errorf("expected new swap in the new hour, got %v %v", pbs[2].Amount0Out, pbs[2].Amount1In)
+	}
+	if !pbs[0].Amount0In.Equal(dec("10")) || pbs[0].MintCount != 1 {
+		t.Errorf("expected the first hour to be untouched, got %v %v", pbs[0].Amount0In, pbs[0].MintCount)
+	}
+	pcs, err = db.GetPairChecks(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !pcs[pair.Hex()].LastCheckAt.Equal(testStart.Add(3 * time.Hour)) {
+		t.Errorf("expected pair check at %v, got %v", testStart.Add(3*time.Hour), pcs[pair.Hex()].LastCheckAt)
+	}
+
+	// the day and week rollups have all the hours in one bucket each
+	last := pairBuckets(t, db, pair)[2]
+	for _, res := range []time.Duration{backend.Day, backend.Week} {
+		start := backend.RollupStart(testStart, res)
+		pbs, err := db.GetPairBuckets(ctx, pair.Hex(), start.Add(-time.Second), start.Add(res), res)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(pbs) != 1 || !pbs[0].Time.Equal(start) {
+			t.Fatalf("expected 1 pair bucket at %v for %v, got %+v", start, res, pbs)
+		}
+		pb := pbs[0]
+		if !pb.Amount0In.Equal(dec("10")) || !pb.Amount0Out.Equal(dec("9")) || pb.MintCount != 1 || !pb.LiquidityRemoved0.Equal(dec("100")) {
+			t.Errorf("%v | expected the flows of every hour, got %+v", res, pb)
+		}
+		// both swaps are from the same account
+		if pb.SwapCount != 2 || pb.UniqueTraders != 1 {
+			t.Errorf("%v | expected 2 swaps by 1 trader, got %v by %v", res, pb.SwapCount, pb.UniqueTraders)
+		}
+		if !pb.Reserve0.Equal(last.Reserve0) {
+			t.Errorf("%v | expected the reserves of the last hour, got %v", res, pb.Reserve0)
+		}
+		totals, err := db.GetTotals(ctx, start.Add(-time.Second), start.Add(res), res)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(totals) != 1 || totals[0].SwapCount != 2 || totals[0].UniqueTraders != 1 {
+			t.Errorf("%v | expected 1 total with 2 swaps by 1 trader, got %+v", res, totals)
+		}
+	}
+}
+
+func TestHistoricalLiquidity(t *testing.T) {
+	ctx := context.Background()
+	chain, pair, fast := setupChain(t)
+	// nothing happens in hours 2 to 4
+	chain.MineUntil(testStart.Add(5*time.Hour + 10*time.Minute))
+	db := backend.NewMock()
+
+	err := FetchData(ctx, chain, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pbs := pairBuckets(t, db, pair)
+	if len(pbs) != 5 {
+		t.Fatalf("expected a pair bucket for each of 5 hours, got %v", len(pbs))
+	}
+	if !pbs[4].Reserve0.Equal(dec("910")) || !pbs[4].VolumeUSD.IsZero() {
+		t.Errorf("expected a quiet hour to keep the reserves, got %v %v", pbs[4].Reserve0, pbs[4].VolumeUSD)
+	}
+
+	// liquidity is from each hour's reserves and prices, FAST is priced in USDC from the same pair
+	totals, err := db.GetTotals(ctx, testStart, testStart.Add(24*time.Hour), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(totals) != 5 {
+		t.Fatalf("expected 5 totals, got %v", len(totals))
+	}
+	for i, exp := range []string{"3962", "3566", "3566", "3566", "3566"} {
+		if !totals[i].LiquidityUSD.Round(6).Equal(dec(exp)) {
+			t.Errorf("hour %v | expected liquidity %v, got %v", i, exp, totals[i].LiquidityUSD)
+		}
+	}
+	tbs, err := db.GetTokenBuckets(ctx, fast.Hex(), testStart, testStart.Add(24*time.Hour), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tbs) != 5 || !tbs[0].Reserve.Equal(dec("1010")) || !tbs[0].LiquidityUSD.Round(6).Equal(dec("1981")) || !tbs[1].Reserve.Equal(dec("910")) {
+		t.Errorf("expected FAST reserves and liquidity as of each hour, got %+v", tbs)
+	}
+}
+
+func TestFetchDataReorg(t *testing.T) {
+	ctx := context.Background()
+	chain, pair, _ := setupChain(t)
+	db := backend.NewMock()
+
+	err := FetchData(ctx, chain, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chain.MineUntil(testStart.Add(150 * time.Minute))
+	chain.Swap(pair, testUser, big.NewInt(0), amount(20, 6), amount(9, 18), big.NewInt(0))
+	chain.MineUntil(testStart.Add(190 * time.Minute))
+	err = FetchData(ctx, chain, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// replace everything since before the swap in the third hour, the swap is gone
+	chain.Reorg(int(chain.HeadTime().Sub(testStart.Add(140*time.Minute)) / testBlockTime))
+	err = FetchData(ctx, chain, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pbs := pairBuckets(t, db, pair)
+	if len(pbs) != 3 || pbs[2] == nil {
+		t.Fatalf("expected 3 pair buckets, got %v", len(pbs))
+	}
+	if !pbs[2].Amount0Out.IsZero() || !pbs[2].VolumeUSD.IsZero() {
+		t.Errorf("expected reorged swap to be gone, got %v %v", pbs[2].Amount0Out, pbs[2].VolumeUSD)
+	}
+	if !pbs[1].LiquidityRemoved0.Equal(dec("100")) {
+		t.Errorf("expected the hour before the reorg to be untouched, got %v", pbs[1].LiquidityRemoved0)
+	}
+	swaps, _, err := db.GetSwaps(ctx, &backend.SwapFilter{Pair: pair.Hex()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(swaps) != 1 || !swaps[0].Amount0In.Equal(dec("10")) {
+		t.Errorf("expected only the swap from before the reorg, got %v", len(swaps))
+	}
+	lc, err := db.GetLastCheck(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash, err := GetBlockHashByNumber(ctx, chain, lc.LastBlockNumber)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hash != lc.LastBlockHash || !lc.LastCheckAt.Equal(testStart.Add(3*time.Hour)) {
+		t.Errorf("expected last check on the new chain at %v, got %+v", testStart.Add(3*time.Hour), lc)
+	}
+}
+
+// failingChain fails every log query for the pair
+type failingChain struct {
+	*fakechain.Chain
+	pair common.Address
+}
+
+func (c *failingChain) FilterLogs(ctx context.Context, q gochain.FilterQuery) ([]types.Log, error) {
+	for _, a := range q.Addresses {
+		if a == c.pair {
+			return nil, errors.New("unavailable")
+		}
+	}
+	return c.Chain.FilterLogs(ctx, q)
+}
+
+func TestFetchDataRetry(t *testing.T) {
+	ctx := context.Background()
+	chain, pair, _ := setupChain(t)
+	db := backend.NewMock()
+
+	err := FetchData(ctx, &failingChain{Chain: chain, pair: pair}, db)
+	if err == nil {
+		t.Fatal("expected the failed pair to be reported")
+	}
+	if pbs := pairBuckets(t, db, pair); len(pbs) != 0 {
+		t.Fatalf("expected no pair buckets, got %v", len(pbs))
+	}
+
+	// nothing new was mined, the pair still gets retried
+	err = FetchData(ctx, chain, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pbs := pairBuckets(t, db, pair)
+	if len(pbs) != 2 || !pbs[0].Amount0In.Equal(dec("10")) || !pbs[1].LiquidityRemoved0.Equal(dec("100")) {
+		t.Fatalf("expected both hours after the retry, got %v", len(pbs))
+	}
+	pcs, err := db.GetPairChecks(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lc, err := db.GetLastCheck(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pc := pcs[pair.Hex()]; pc.LastBlockNumber != lc.LastBlockNumber || !pc.LastCheckAt.Equal(testStart.Add(2*time.Hour)) {
+		t.Errorf("expected the pair caught up to the last check %+v, got %+v", lc, pc)
+	}
+	if len(lc.Checkpoints) != 1 {
+		t.Errorf("expected the retry not to add a checkpoint, got %v", len(lc.Checkpoints))
+	}
+}
+
+func TestUpdatePartial(t *testing.T) {
+	ctx := context.Background()
+	chain, pair, _ := setupChain(t)
+	db := backend.NewMock()
+
+	err := FetchData(ctx, chain, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chain.MineUntil(testStart.Add(140 * time.Minute))
+	chain.Swap(pair, testUser, big.NewInt(0), amount(20, 6), amount(9, 18), big.NewInt(0))
+	head := chain.MineUntil(testStart.Add(150 * time.Minute))
+	err = UpdatePartial(ctx, chain, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pbs := pairBuckets(t, db, pair)
+	if len(pbs) != 3 || pbs[2] == nil {
+		t.Fatalf("expected 3 pair buckets, got %v", len(pbs))
+	}
+	if !pbs[2].Amount0Out.Equal(dec("9")) {
+		t.Errorf("expected the swap in the hour in progress, got %v", pbs[2].Amount0Out)
+	}
+	lc, err := db.GetLastCheck(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lc.PartialBlockNumber != head || !lc.LastCheckAt.Equal(testStart.Add(2*time.Hour)) {
+		t.Errorf("expected partial at block %v and last check unmoved, got %+v", head, lc)
+	}
+
+	// running again doesn't count anything twice, and finalizing the hour gives the same result
+	err = UpdatePartial(ctx, chain, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chain.MineUntil(testStart.Add(190 * time.Minute))
+	err = FetchData(ctx, chain, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pbs = pairBuckets(t, db, pair)
+	if len(pbs) != 3 || !pbs[2].Amount0Out.Equal(dec("9")) || !pbs[2].Amount1In.Equal(dec("20")) {
+		t.Errorf("expected the finalized hour to have the swap once, got %+v", pbs[2])
+	}
+	swaps, _, err := db.GetSwaps(ctx, &backend.SwapFilter{From: testStart.Add(2 * time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(swaps) != 1 || !swaps[0].Amount0Out.Equal(dec("9")) {
+		t.Errorf("expected the swap in the finalized hour once, got %v", len(swaps))
+	}
+}
+
+func TestCandles(t *testing.T) {
+	ctx := context.Background()
+	chain, pair, fast := setupChain(t)
+	db := backend.NewMock()
+
+	err := FetchData(ctx, chain, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	div := func(a, b string) decimal.Decimal { return dec(a).DivRound(dec(b), 18) }
+	// the first hour has the mint's Sync, the swap at 19/10 and the swap's Sync, the second only the
+	// burn's Sync
+	tests := []models.OHLC{
+		{Open: div("2000", "1000"), High: div("2000", "1000"), Low: div("19", "10"), Close: div("1981", "1010")},
+		{Open: div("1783", "910"), High: div("1783", "910"), Low: div("1783", "910"), Close: div("1783", "910")},
+	}
+	pbs := pairBuckets(t, db, pair)
+	for i, want := range tests {
+		if got := pbs[i].OHLC0; !got.Open.Equal(want.Open) || !got.High.Equal(want.High) || !got.Low.Equal(want.Low) || !got.Close.Equal(want.Close) {
+			t.Errorf("hour %v | expected candle %+v, got %+v", i, want, got)
+		}
+	}
+
+	// FAST only trades against USDC, so its USD candle is the pair's priced in USDC
+	tbs, err := db.GetTokenBuckets(ctx, fast.Hex(), testStart, testStart.Add(24*time.Hour), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tbs) != 2 {
+		t.Fatalf("expected 2 token buckets, got %v", len(tbs))
+	}
+	for i, tb := range tbs {
+		want := pbs[i].OHLC0.Mul(pbs[i].Price1USD)
+		if tb.OHLCUSD.IsZero() || !tb.OHLCUSD.Low.Equal(want.Low) || !tb.OHLCUSD.Close.Equal(want.Close) {
+			t.Errorf("hour %v | expected USD candle %+v, got %+v", i, want, tb.OHLCUSD)
+		}
+	}
+
+	// rolled up, it opens with the first hour and closes with the last
+	pbs2, err := db.GetPairBuckets(ctx, pair.Hex(), testStart, testStart.Add(24*time.Hour), 3*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pbs2) != 1 {
+		t.Fatalf("expected 1 bucket, got %v", len(pbs2))
+	}
+	if c := pbs2[0].OHLC0; !c.Open.Equal(tests[0].Open) || !c.Low.Equal(tests[0].Low) || !c.Close.Equal(tests[1].Close) {
+		t.Errorf("expected the candles combined, got %+v", c)
+	}
+}
+
+func TestTWAP(t *testing.T) {
+	ctx := context.Background()
+	chain, pair, _ := setupChain(t)
+	db := backend.NewMock()
+
+	err := FetchData(ctx, chain, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pbs := pairBuckets(t, db, pair)
+	if len(pbs) != 2 {
+		t.Fatalf("expected 2 pair buckets, got %v", len(pbs))
+	}
+	// the pair was created at 5s and got liquidity at 10s, the swap was at 60s and the burn at 65m
+	tests := []struct {
+		pb      *models.PairBucket
+		seconds int64
+		twap0   decimal.Decimal
+		twap1   decimal.Decimal
+	}{
+		{
+			pbs[0], 3590,
+			dec("2").Mul(dec("50")).Add(dec("1981").Div(dec("1010")).Mul(dec("3535"))).Div(dec("3590")),
+			dec("0.5").Mul(dec("50")).Add(dec("1010").Div(dec("1981")).Mul(dec("3535"))).Div(dec("3590")),
+		},
+		{
+			pbs[1], 3600,
+			dec("1981").Div(dec("1010")).Mul(dec("305")).Add(dec("1783").Div(dec("910")).Mul(dec("3295"))).Div(dec("3600")),
+			dec("1010").Div(dec("1981")).Mul(dec("305")).Add(dec("910").Div(dec("1783")).Mul(dec("3295"))).Div(dec("3600")),
+		},
+	}
+	for i, test := range tests {
+		pb := test.pb
+		if pb.TWAPSeconds != test.seconds {
+			t.Errorf("test %v | expected TWAP over %v seconds, got %v", i, test.seconds, pb.TWAPSeconds)
+		}
+		if pb.TWAP0.Sub(test.twap0).Abs().GreaterThan(dec("0.000001")) {
+			t.Errorf("test %v | expected TWAP0 %v, got %v", i, test.twap0, pb.TWAP0)
+		}
+		if pb.TWAP1.Sub(test.twap1).Abs().GreaterThan(dec("0.000001")) {
+			t.Errorf("test %v | expected TWAP1 %v, got %v", i, test.twap1, pb.TWAP1)
+		}
+		if !pb.CumulativeAt.Equal(testStart.Add(time.Duration(i+1) * time.Hour).Add(-testBlockTime)) {
+			t.Errorf("test %v | expected cumulative prices at the last block in the hour, got %v", i, pb.CumulativeAt)
+		}
+	}
+}
+
+func TestBuildPriceGraph(t *testing.T) {
+	stables := StablecoinAddresses
+	defer func() { StablecoinAddresses = stables }()
+
+	tokens := map[string]*models.Token{}
+	for i, s := range []string{"USDC", "WGO", "TOKEN", "X", "Y", "FAKEUSDC"} {
+		tokens[s] = &models.Token{Symbol: s, Address: common.BigToAddress(big.NewInt(int64(i + 1)))}
+	}
+	// anyone can call their token USDC
+	tokens["FAKEUSDC"].Symbol = "USDC"
+	StablecoinAddresses = map[common.Address]bool{tokens["USDC"].Address: true}
+
+	edge := func(s0, s1 string, r0, r1 string) *pairReserves {
+		return &pairReserves{pair: &models.Pair{Token0: tokens[s0], Token1: tokens[s1]}, reserve0: dec(r0), reserve1: dec(r1)}
+	}
+	edges := []*pairReserves{
+		edge("WGO", "USDC", "100000", "5000"),        // WGO is 0.05
+		edge("TOKEN", "WGO", "1000", "20000"),        // TOKEN is 1 through WGO
+		edge("TOKEN", "USDC", "10", "50"),            // too thin to use
+		edge("X", "TOKEN", "100", "1000"),            // X is 10, 3 hops
+		edge("Y", "X", "10", "1000"),                 // 4 hops, too far
+		edge("WGO", "FAKEUSDC", "100000", "1000000"), // isn't a stablecoin, so doesn't make WGO 10
+	}
+
+	prices, _ := buildPriceGraph(edges)
+	tests := []struct {
+		symbol string
+		price  decimal.Decimal
+		hops   int
+		depth  decimal.Decimal
+	}{
+		{"WGO", dec("0.05"), 1, dec("10000")},
+		{"TOKEN", dec("1"), 2, dec("2000")},
+		{"X", dec("10"), 3, dec("2000")},
+		{"FAKEUSDC", dec("0.005"), 2, dec("10000")},
+	}
+	for i, test := range tests {
+		tp := prices[tokens[test.symbol].Address]
+		if tp == nil {
+			t.Fatalf("test %v | expected a price for %v", i, test.symbol)
+		}
+		if !tp.price.Equal(test.price) || len(tp.path) != test.hops || !tp.depth.Equal(test.depth) {
+			t.Errorf("test %v | expected %v at %v in %v hops, got %v at %v in %v", i, test.symbol, test.price, test.hops, tp.price, tp.depth, len(tp.path))
+		}
+	}
+	if prices[tokens["Y"].Address] != nil {
+		t.Errorf("expected no price for Y past the max hops, got %v", prices[tokens["Y"].Address].price)
+	}
+
+	all := make([]*models.Token, 0, len(tokens))
+	for _, tok := range tokens {
+		all = append(all, tok)
+	}
+	collisions := models.SymbolCollisions(all)
+	if len(collisions) != 1 || len(collisions["USDC"]) != 2 {
+		t.Errorf("expected USDC to collide, got %v", collisions)
+	}
+}
+
+func TestStablecoinPricing(t *testing.T) {
+	stables := StablecoinAddresses
+	defer func() { StablecoinAddresses = stables }()
+
+	tokens := map[string]*models.Token{}
+	for i, s := range []string{"USDC", "USDT", "DAI", "USDX", "TOKEN"} {
+		tokens[s] = &models.Token{Symbol: s, Address: common.BigToAddress(big.NewInt(int64(i + 1)))}
+	}
+	StablecoinAddresses = map[common.Address]bool{}
+	for _, s := range []string{"USDC", "USDT", "DAI", "USDX"} {
+		StablecoinAddresses[tokens[s].Address] = true
+	}
+	edge := func(s0, s1 string, r0, r1 string) *pairReserves {
+		return &pairReserves{pair: &models.Pair{Token0: tokens[s0], Token1: tokens[s1]}, reserve0: dec(r0), reserve1: dec(r1)}
+	}
+	edges := []*pairReserves{
+		edge("USDC", "USDT", "100000", "100000"),
+		edge("USDC", "DAI", "100000", "100000"),
+		edge("USDT", "DAI", "100000", "100000"),
+		edge("USDX", "USDC", "100000", "90000"), // USDX lost its peg
+		edge("TOKEN", "USDC", "1000", "2000"),   // 2 with 4000 liquidity
+		edge("TOKEN", "USDT", "1000", "2200"),   // 2.2 with 4400 liquidity
+	}
+
+	prices, depegged := buildPriceGraph(edges)
+	if len(depegged) != 1 || !depegged[tokens["USDX"].Address].Equal(dec("0.9")) {
+		t.Fatalf("expected only USDX to be depegged at 0.9, got %v", depegged)
+	}
+	tests := []struct {
+		symbol string
+		price  decimal.Decimal
+	}{
+		{"USDC", dec("1")},
+		{"USDT", dec("1")},
+		{"USDX", dec("0.9")},
+		{"TOKEN", dec("17680").Div(dec("8400"))},
+	}
+	for i, test := range tests {
+		tp := prices[tokens[test.symbol].Address]
+		if tp == nil {
+			t.Fatalf("test %v | expected a price for %v", i, test.symbol)
+		}
+		if !tp.price.Equal(test.price) {
+			t.Errorf("test %v | expected %v at %v, got %v", i, test.symbol, test.price, tp.price)
+		}
+	}
+}
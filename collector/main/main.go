@@ -2,10 +2,17 @@ package main
 
 import (
 	"context"
+	"flag"
 	"log"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
 
 	"github.com/gochain/gochain/v4/goclient"
 	"github.com/gochain/gochain/v4/rpc"
+	"github.com/goswap/stats-api/backend"
 	"github.com/goswap/stats-api/collector"
 	"github.com/treeder/firetils"
 	"github.com/treeder/gcputils"
@@ -21,35 +28,142 @@ So this script will run on a schedule and collect the following information:
 1) Volume over time
 2) List of all pairs with their total liquidity and current price
 3) 24 hr volume on those pairs
+
+Use -db sqlite3 or -db postgres with -dsn to store stats in SQL instead of firestore.
+
+Run with `backfill` to rebuild history for a range instead:
+
+	collector backfill --from-block 1000 --to-block 2000
+	collector backfill --since 2021-05-01T00:00:00Z --until 2021-05-08T00:00:00Z
+
+Or with `watch` to keep running and keep the hour in progress up to date:
+
+	collector watch --interval 30s
 */
 func main() {
 	ctx := context.Background()
 
-	acc, opts, err := gcputils.AccountAndCredentialsFromEnv("G_KEY")
-	if err != nil {
-		log.Fatal(err)
-	}
-	// lc, err := gcputils.InitLogging(ctx, projectID, opts)
-	// if err != nil {
-	// 	log.Fatal(err)
-	// }
-	// defer lc.Close()
-	fireapp, err := firetils.New(ctx, acc.ProjectID, opts)
-	if err != nil {
-		log.Fatalf("couldn't init firebase app: %v\n", err)
+	dbType := flag.String("db", "firestore", "where stats are stored: firestore, sqlite3 or postgres")
+	dsn := flag.String("dsn", os.Getenv("DATABASE_URL"), "data source name for sqlite3 or postgres")
+	flag.Parse()
+
+	var db backend.Backend
+	var err error
+	switch *dbType {
+	case "firestore":
+		acc, opts, err := gcputils.AccountAndCredentialsFromEnv("G_KEY")
+		if err != nil {
+			log.Fatal(err)
+		}
+		// lc, err := gcputils.InitLogging(ctx, projectID, opts)
+		// if err != nil {
+		// 	log.Fatal(err)
+		// }
+		// defer lc.Close()
+		fireapp, err := firetils.New(ctx, acc.ProjectID, opts)
+		if err != nil {
+			log.Fatalf("couldn't init firebase app: %v\n", err)
+		}
+		firestore, err := fireapp.Firestore(ctx)
+		if err != nil {
+			log.Fatalf("couldn't init firestore: %v\n", err)
+		}
+		db, err = backend.NewFirestore(ctx, firestore)
+		if err != nil {
+			log.Fatalf("couldn't init firestore backend: %v\n", err)
+		}
+	case backend.DriverSQLite, backend.DriverPostgres:
+		db, err = backend.NewSQL(ctx, *dbType, *dsn)
+		if err != nil {
+			log.Fatalf("couldn't init %v: %v\n", *dbType, err)
+		}
+	default:
+		log.Fatalf("unknown db %q\n", *dbType)
 	}
-	firestore, err := fireapp.Firestore(ctx)
-	if err != nil {
-		log.Fatalf("couldn't init firestore: %v\n", err)
+
+	if cd := os.Getenv("CONFIRMATION_DEPTH"); cd != "" {
+		collector.ConfirmationDepth, err = strconv.ParseInt(cd, 10, 64)
+		if err != nil {
+			log.Fatalf("invalid CONFIRMATION_DEPTH %q: %v\n", cd, err)
+		}
+	}
+	if fsb := os.Getenv("FACTORY_START_BLOCK"); fsb != "" {
+		collector.FactoryStartBlock, err = strconv.ParseInt(fsb, 10, 64)
+		if err != nil {
+			log.Fatalf("invalid FACTORY_START_BLOCK %q: %v\n", fsb, err)
+		}
+	}
+	if sc := os.Getenv("STABLECOINS"); sc != "" {
+		collector.StablecoinAddresses, err = collector.ParseStablecoins(sc)
+		if err != nil {
+			log.Fatalf("invalid STABLECOINS: %v\n", err)
+		}
+	}
+	if fr := os.Getenv("FEE_RATE"); fr != "" {
+		collector.FeeRate, err = collector.ParseFeeRate(fr)
+		if err != nil {
+			log.Fatalf("invalid FEE_RATE: %v\n", err)
+		}
 	}
 
+	if u := os.Getenv("RPC_URL"); u != "" {
+		// an archive node, for backfilling with the prices from back then
+		rpcURL = u
+	}
 	rpcClient, err := rpc.Dial(rpcURL)
 	if err != nil {
 		log.Fatalf("failed to dial rpc %q: %v", rpcURL, err)
 	}
 	rpc := goclient.NewClient(rpcClient)
 
-	err = collector.FetchData(ctx, rpc, firestore)
+	if flag.NArg() > 0 && flag.Arg(0) == "backfill" {
+		fset := flag.NewFlagSet("backfill", flag.ExitOnError)
+		fromBlock := fset.Int64("from-block", 0, "first block to backfill")
+		toBlock := fset.Int64("to-block", 0, "last block to backfill, defaults to the latest confirmed block")
+		since := fset.String("since", "", "RFC3339 time to backfill from, instead of --from-block")
+		until := fset.String("until", "", "RFC3339 time to backfill to, instead of --to-block")
+		fset.Parse(flag.Args()[1:])
+		if *since != "" {
+			sinceT, err := time.Parse(time.RFC3339, *since)
+			if err != nil {
+				log.Fatalf("invalid --since %q: %v\n", *since, err)
+			}
+			var untilT time.Time
+			if *until != "" {
+				untilT, err = time.Parse(time.RFC3339, *until)
+				if err != nil {
+					log.Fatalf("invalid --until %q: %v\n", *until, err)
+				}
+			}
+			*fromBlock, *toBlock, err = collector.BlockRangeForTimes(ctx, rpc, sinceT, untilT)
+			if err != nil {
+				log.Fatalf("couldn't find blocks for %v to %v: %v\n", *since, *until, err)
+			}
+		}
+		if *fromBlock <= 0 {
+			log.Fatal("backfill needs --from-block or --since")
+		}
+		err = collector.Backfill(ctx, rpc, db, *fromBlock, *toBlock)
+		if err != nil {
+			log.Fatalf("error on Backfill: %v\n", err)
+		}
+		return
+	}
+
+	if flag.NArg() > 0 && flag.Arg(0) == "watch" {
+		fset := flag.NewFlagSet("watch", flag.ExitOnError)
+		interval := fset.Duration("interval", 30*time.Second, "how often to poll for new blocks")
+		fset.Parse(flag.Args()[1:])
+		ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		err = collector.Watch(ctx, rpc, db, *interval)
+		if err != nil && ctx.Err() == nil {
+			log.Fatalf("error on Watch: %v\n", err)
+		}
+		return
+	}
+
+	err = collector.FetchData(ctx, rpc, db)
 	if err != nil {
 		gotils.C(ctx).Printf("error on FetchData: %v", err)
 	}
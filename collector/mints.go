@@ -14,7 +14,6 @@ import (
 	"github.com/gochain/gochain/v4/accounts/abi"
 	"github.com/gochain/gochain/v4/common"
 	"github.com/gochain/gochain/v4/core/types"
-	"github.com/gochain/gochain/v4/goclient"
 	"github.com/goswap/stats-api/contracts"
 	"github.com/treeder/gotils/v2"
 )
@@ -27,13 +26,15 @@ type MintEvent struct {
 	BlockNumber     int64
 	TransactionHash string
 	TxHash          common.Hash
+	LogIndex        uint
 	Amount0         *big.Int
 	Amount1         *big.Int
+	Timestamp       time.Time
 }
 
 var mintEventID = common.HexToHash("0x4c209b5fc8ad50758f13e2e1088ba56a560dff690a1c6fef26394f4c03821c4f")
 
-func GetMintEvents(ctx context.Context, rpc *goclient.Client, pairAddress common.Address, startBlock, endBlock int64, blockLimit uint64) ([]*MintEvent, error) {
+func GetMintEvents(ctx context.Context, rpc ChainReader, pairAddress common.Address, startBlock, endBlock int64, blockLimit uint64) ([]*MintEvent, error) {
 	abi, err := abi.JSON(strings.NewReader(contracts.PairABI))
 	if err != nil {
 		fmt.Println("Failed to parse token Uniswap ABI:", err)
@@ -74,6 +75,8 @@ func GetMintEvents(ctx context.Context, rpc *goclient.Client, pairAddress common
 		if err != nil {
 			return nil, fmt.Errorf("failed to query RPC for logs: %v", err)
 		}
+		var currentTimeStamp time.Time
+		var currentBlockNumber int64
 		for _, log := range logs {
 			event, err := unpackMintEvent(ctx, abi, log)
 			if err != nil {
@@ -89,6 +92,14 @@ func GetMintEvents(ctx context.Context, rpc *goclient.Client, pairAddress common
 			event.TxFrom = *tx.From
 			// fmt.Printf("tx: %v to: %v sender: %v from: %v\n", event.TransactionHash, event.TxFrom.Hex(), event.Sender.Hex(), event.FromContract.Hex())
 
+			if currentBlockNumber != event.BlockNumber || currentTimeStamp.IsZero() {
+				currentTimeStamp, err = GetTimestampByBlockNumber(ctx, rpc, event.BlockNumber)
+				if err != nil {
+					return nil, gotils.C(ctx).Errorf("Failed to get block timestamp: %v", err)
+				}
+			}
+			event.Timestamp = currentTimeStamp
+			currentBlockNumber = event.BlockNumber
 		}
 		currentBlock = toBlock + 1
 	}
@@ -112,5 +123,6 @@ func unpackMintEvent(ctx context.Context, abi abi.ABI, event types.Log) (*MintEv
 	mintEvent.BlockNumber = int64(event.BlockNumber)
 	mintEvent.TransactionHash = event.TxHash.String()
 	mintEvent.TxHash = event.TxHash
+	mintEvent.LogIndex = event.Index
 	return &mintEvent, nil
 }
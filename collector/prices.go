@@ -0,0 +1,301 @@
+package collector
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/gochain/gochain/v4/common"
+	"github.com/goswap/stats-api/models"
+	"github.com/shopspring/decimal"
+	"github.com/treeder/gotils/v2"
+)
+
+// USDCAddress is USDC on GoChain
+const USDCAddress = "0x97a19aD887262d7Eca45515814cdeF75AcC4f713"
+
+var (
+	// StablecoinAddresses are the tokens trusted to be worth $1, every other price is derived from
+	// these. They're matched by address so a token that calls itself USDC can't set prices.
+	StablecoinAddresses = map[common.Address]bool{
+		common.HexToAddress(USDCAddress): true,
+	}
+	// DepegThreshold is how far a stablecoin can be from $1, priced against the others, before it's
+	// considered depegged and isn't used for pricing anymore
+	DepegThreshold = decimal.NewFromFloat(0.02)
+
+	// MaxPriceHops is the most pairs a price can go through to get to USDC, eg: TOKEN->WGO->USDC is 2
+	MaxPriceHops = 3
+	// MinPriceLiquidityUSD is the least liquidity a pair needs to be used for pricing, so a thin pool
+	// can't set the price of a token
+	MinPriceLiquidityUSD = decimal.NewFromInt(1000)
+
+	// prices by token address and the depegged stablecoins, from the last price graph built
+	tokenPrices     = map[common.Address]*tokenPrice{}
+	depeggedStables = map[common.Address]decimal.Decimal{}
+
+	// the pairs the last price graph was built from and the graphs built from them at past blocks
+	pricedPairs []*models.Pair
+	blockPrices = map[int64]*priceGraph{}
+)
+
+// priceGraph is the prices of all the tokens as of a block
+type priceGraph struct {
+	prices   map[common.Address]*tokenPrice
+	depegged map[common.Address]decimal.Decimal
+}
+
+// price returns the USD price of the token, see PriceInUSD
+func (g *priceGraph) price(address common.Address) (decimal.Decimal, error) {
+	tp := g.prices[address]
+	if tp == nil {
+		if _, depegged := g.depegged[address]; StablecoinAddresses[address] && !depegged {
+			return decimal.NewFromInt(1), nil
+		}
+		return decimal.Zero, &models.PriceNotFound{}
+	}
+	return tp.price, nil
+}
+
+// tokenPrice is the USD price of a token and the path it was derived through
+type tokenPrice struct {
+	price decimal.Decimal
+	// depth is the USD liquidity of the shallowest pair on the path, or of all the pairs for tokens
+	// priced directly against stablecoins
+	depth decimal.Decimal
+	path  []*models.Pair
+}
+
+// pairReserves is a pair and its current reserves, an edge in the price graph
+type pairReserves struct {
+	pair               *models.Pair
+	reserve0, reserve1 decimal.Decimal
+}
+
+// ParseStablecoins parses a comma separated list of token addresses, for setting StablecoinAddresses
+func ParseStablecoins(s string) (map[common.Address]bool, error) {
+	stables := map[common.Address]bool{}
+	for _, a := range strings.Split(s, ",") {
+		a = strings.TrimSpace(a)
+		if !common.IsHexAddress(a) {
+			return nil, fmt.Errorf("invalid stablecoin address %q", a)
+		}
+		stables[common.HexToAddress(a)] = true
+	}
+	return stables, nil
+}
+
+// buildPriceGraph prices every token it can reach from a stablecoin in at most MaxPriceHops pairs. Tokens
+// paired directly with stablecoins get the liquidity weighted average over all those pairs. Past that, when
+// there's more than one path to a token the deepest one wins, that's the one whose shallowest pair has the
+// most liquidity. Pairs with less than MinPriceLiquidityUSD are left out. Depegged stablecoins (see
+// findDepegged) are returned too, they get priced like any other token instead of at $1.
+func buildPriceGraph(edges []*pairReserves) (map[common.Address]*tokenPrice, map[common.Address]decimal.Decimal) {
+	depegged := findDepegged(edges)
+	refs := map[common.Address]bool{}
+	prices := map[common.Address]*tokenPrice{}
+	for a := range StablecoinAddresses {
+		if _, ok := depegged[a]; !ok {
+			refs[a] = true
+			prices[a] = &tokenPrice{price: decimal.NewFromInt(1)}
+		}
+	}
+	two := decimal.NewFromInt(2)
+	// one hop further each time, like Bellman-Ford, so the hop limit holds
+	for hop := 0; hop < MaxPriceHops; hop++ {
+		next := map[common.Address]*tokenPrice{}
+		for k, v := range prices {
+			next[k] = v
+		}
+		// pairs straight from a stablecoin, price is the sum of price * liquidity until it's divided below
+		weighted := map[common.Address]*tokenPrice{}
+		deepest := map[common.Address]decimal.Decimal{}
+		for _, e := range edges {
+			for _, dir := range []struct {
+				from, to               common.Address
+				fromReserve, toReserve decimal.Decimal
+			}{
+				{e.pair.Token0.Address, e.pair.Token1.Address, e.reserve0, e.reserve1},
+				{e.pair.Token1.Address, e.pair.Token0.Address, e.reserve1, e.reserve0},
+			} {
+				from := prices[dir.from]
+				if from == nil || refs[dir.to] || dir.toReserve.IsZero() {
+					continue
+				}
+				// both sides of a pool are worth the same
+				liquidityUSD := dir.fromReserve.Mul(from.price).Mul(two)
+				if liquidityUSD.LessThan(MinPriceLiquidityUSD) {
+					continue
+				}
+				price := dir.fromReserve.Div(dir.toReserve).Mul(from.price)
+				if refs[dir.from] {
+					w := weighted[dir.to]
+					if w == nil {
+						w = &tokenPrice{}
+						weighted[dir.to] = w
+					}
+					w.price = w.price.Add(price.Mul(liquidityUSD))
+					w.depth = w.depth.Add(liquidityUSD)
+					if deepest[dir.to].LessThan(liquidityUSD) {
+						deepest[dir.to] = liquidityUSD
+						w.path = []*models.Pair{e.pair}
+					}
+					continue
+				}
+				depth := liquidityUSD
+				if from.depth.LessThan(depth) {
+					depth = from.depth
+				}
+				if cur := next[dir.to]; cur != nil && !cur.depth.LessThan(depth) {
+					continue
+				}
+				next[dir.to] = &tokenPrice{
+					price: price,
+					depth: depth,
+					path:  append(append([]*models.Pair{}, from.path...), e.pair),
+				}
+			}
+		}
+		for a, w := range weighted {
+			w.price = w.price.Div(w.depth)
+			if cur := next[a]; cur != nil && !cur.depth.LessThan(w.depth) {
+				continue
+			}
+			next[a] = w
+		}
+		prices = next
+	}
+	return prices, depegged
+}
+
+// findDepegged prices each stablecoin against the others, liquidity weighted over the pairs between them,
+// and returns the ones more than DepegThreshold off $1 with their price. The worst one is flagged first and
+// the rest are compared again without it, so one depeg doesn't drag the others off too. It takes at least
+// three stablecoins paired with each other to tell which one moved, with two both look equally off.
+func findDepegged(edges []*pairReserves) map[common.Address]decimal.Decimal {
+	depegged := map[common.Address]decimal.Decimal{}
+	one := decimal.NewFromInt(1)
+	two := decimal.NewFromInt(2)
+	for {
+		var worst common.Address
+		var worstPrice, worstOff decimal.Decimal
+		for s := range StablecoinAddresses {
+			if _, ok := depegged[s]; ok {
+				continue
+			}
+			sum, liquidity := decimal.Zero, decimal.Zero
+			for _, e := range edges {
+				var other common.Address
+				var reserve, otherReserve decimal.Decimal
+				switch s {
+				case e.pair.Token0.Address:
+					other, reserve, otherReserve = e.pair.Token1.Address, e.reserve0, e.reserve1
+				case e.pair.Token1.Address:
+					other, reserve, otherReserve = e.pair.Token0.Address, e.reserve1, e.reserve0
+				default:
+					continue
+				}
+				if _, ok := depegged[other]; !StablecoinAddresses[other] || ok || reserve.IsZero() {
+					continue
+				}
+				l := otherReserve.Mul(two)
+				if l.LessThan(MinPriceLiquidityUSD) {
+					continue
+				}
+				sum = sum.Add(otherReserve.Div(reserve).Mul(l))
+				liquidity = liquidity.Add(l)
+			}
+			if liquidity.IsZero() {
+				continue
+			}
+			price := sum.Div(liquidity)
+			off := price.Sub(one).Abs()
+			if off.GreaterThan(DepegThreshold) && off.GreaterThan(worstOff) {
+				worst, worstPrice, worstOff = s, price, off
+			}
+		}
+		if worstOff.IsZero() {
+			return depegged
+		}
+		fmt.Printf("WARN: stablecoin %v has depegged, price against the others: %v\n", worst.Hex(), worstPrice)
+		depegged[worst] = worstPrice
+	}
+}
+
+// readEdges reads the reserves of all the pairs as of blockNumber, 0 for latest. Pairs created after
+// blockNumber are left out.
+func readEdges(ctx context.Context, pairs []*models.Pair, blockNumber int64) ([]*pairReserves, error) {
+	edges := make([]*pairReserves, 0, len(pairs))
+	for _, p := range pairs {
+		if blockNumber > 0 && p.CreatedBlock > blockNumber {
+			continue
+		}
+		reserve0, reserve1, err := p.GetReserves(ctx, blockNumber)
+		if err != nil {
+			return nil, gotils.C(ctx).Errorf("error getting reserves for %v: %v", p.String(), err)
+		}
+		edges = append(edges, &pairReserves{pair: p, reserve0: reserve0, reserve1: reserve1})
+	}
+	return edges, nil
+}
+
+// updatePrices rebuilds the price graph from the current reserves of all the pairs
+func updatePrices(ctx context.Context, pairs []*models.Pair) error {
+	edges, err := readEdges(ctx, pairs, 0)
+	if err != nil {
+		return err
+	}
+	prices, depegged := buildPriceGraph(edges)
+	for address, tp := range prices {
+		if len(tp.path) == 0 {
+			continue
+		}
+		route := ""
+		for _, p := range tp.path {
+			route += " " + p.String()
+		}
+		// the token at the end of the path is the one being priced
+		last := tp.path[len(tp.path)-1]
+		symbol := last.Token0.Symbol
+		if last.Token1.Address == address {
+			symbol = last.Token1.Symbol
+		}
+		fmt.Printf("Current price of %v (%v): %v via%v, depth: %v\n", symbol, address.Hex(), tp.price, route, tp.depth.StringFixed(2))
+	}
+	mu.Lock()
+	tokenPrices = prices
+	depeggedStables = depegged
+	pricedPairs = pairs
+	blockPrices = map[int64]*priceGraph{}
+	mu.Unlock()
+	return nil
+}
+
+// pricesAt returns the price graph as of blockNumber, built from the same pairs as the current one.
+// Reading old blocks needs an archive node, if that fails the current prices are used instead.
+func pricesAt(ctx context.Context, blockNumber int64) *priceGraph {
+	mu.RLock()
+	g := blockPrices[blockNumber]
+	pairs := pricedPairs
+	current := &priceGraph{prices: tokenPrices, depegged: depeggedStables}
+	mu.RUnlock()
+	if g != nil {
+		return g
+	}
+	edges, err := readEdges(ctx, pairs, blockNumber)
+	if err != nil {
+		fmt.Printf("WARN: can't read reserves at block %v, using current prices: %v\n", blockNumber, err)
+		return current
+	}
+	g = &priceGraph{}
+	g.prices, g.depegged = buildPriceGraph(edges)
+	mu.Lock()
+	blockPrices[blockNumber] = g
+	mu.Unlock()
+	return g
+}
+
+// PriceInUSDAt returns the USD price of the token as of blockNumber, like PriceInUSD
+func PriceInUSDAt(ctx context.Context, address common.Address, blockNumber int64) (decimal.Decimal, error) {
+	return pricesAt(ctx, blockNumber).price(address)
+}
@@ -0,0 +1,67 @@
+package collector
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/goswap/stats-api/models"
+	"github.com/treeder/gotils/v2"
+)
+
+const (
+	// how many checkpoints to keep around to rewind to, one is written per run (about hourly)
+	maxCheckpoints = 48
+)
+
+var (
+	// ConfirmationDepth is how many blocks behind the head we stay, blocks newer than this
+	// aren't considered final and won't be processed yet
+	ConfirmationDepth = int64(60)
+)
+
+// lastGoodCheckpoint walks back through the checkpoints to the newest one whose block is still on the
+// canonical chain and returns its index. If nothing was reorged, this is the last checkpoint.
+func lastGoodCheckpoint(ctx context.Context, rpc ChainReader, lc *models.LastCheck) (int, error) {
+	for i := len(lc.Checkpoints) - 1; i >= 0; i-- {
+		cp := lc.Checkpoints[i]
+		hash, err := GetBlockHashByNumber(ctx, rpc, cp.BlockNumber)
+		if err != nil {
+			return -1, gotils.C(ctx).Errorf("error getting block hash: %v", err)
+		}
+		if hash == cp.BlockHash {
+			return i, nil
+		}
+		fmt.Printf("block %v hash mismatch, have %v, chain has %v\n", cp.BlockNumber, cp.BlockHash, hash)
+	}
+	return -1, gotils.C(ctx).Errorf("reorg is deeper than all %v checkpoints", len(lc.Checkpoints))
+}
+
+// lastBlockBefore returns the highest block between lo and hi with a timestamp before t, or lo-1 if there isn't one
+func lastBlockBefore(ctx context.Context, rpc ChainReader, lo, hi int64, t time.Time) (int64, error) {
+	found := lo - 1
+	for lo <= hi {
+		mid := lo + (hi-lo)/2
+		ts, err := GetTimestampByBlockNumber(ctx, rpc, mid)
+		if err != nil {
+			return 0, err
+		}
+		if ts.Before(t) {
+			found = mid
+			lo = mid + 1
+		} else {
+			hi = mid - 1
+		}
+	}
+	return found, nil
+}
+
+// GetBlockHashByNumber returns the hash of the canonical block at blockNumber
+func GetBlockHashByNumber(ctx context.Context, rpc ChainReader, blockNumber int64) (string, error) {
+	header, err := rpc.HeaderByNumber(ctx, big.NewInt(blockNumber))
+	if err != nil {
+		return "", err
+	}
+	return header.Hash().Hex(), nil
+}
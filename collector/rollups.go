@@ -0,0 +1,76 @@
+package collector
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/goswap/stats-api/backend"
+	"github.com/treeder/gotils/v2"
+)
+
+// rollupPeriods rebuilds the daily and weekly buckets for every period that overlaps from to to, each
+// from the stored buckets one resolution finer. Run it after the hourly buckets in that range change.
+func rollupPeriods(ctx context.Context, db backend.Backend, from, to time.Time) error {
+	if !from.Before(to) {
+		return nil
+	}
+	for i := 1; i < len(backend.Resolutions); i++ {
+		res := backend.Resolutions[i]
+		n := 0
+		for start := backend.RollupStart(from, res); start.Before(to); start = start.Add(res) {
+			err := rollupPeriod(ctx, db, backend.Resolutions[i-1], res, start)
+			if err != nil {
+				return err
+			}
+			n++
+		}
+		fmt.Printf("Rolled up %v periods of %v\n", n, res)
+	}
+	return nil
+}
+
+// rollupPeriod merges the buckets at finer resolution in the period at res starting at start into one
+// bucket per pair, token and total and stores them in place of what was there
+func rollupPeriod(ctx context.Context, db backend.Backend, finer, res time.Duration, start time.Time) error {
+	// the range is exclusive, reading at the finer interval returns each bucket in it as is
+	from, to := start.Add(-time.Second), start.Add(res-time.Second)
+
+	period := func(time.Time) time.Time { return start }
+
+	pbs, err := db.GetPairBuckets(ctx, "", from, to, finer)
+	if err != nil {
+		return gotils.C(ctx).Errorf("error on GetPairBuckets: %v", err)
+	}
+	tbs, err := db.GetTokenBuckets(ctx, "", from, to, finer)
+	if err != nil {
+		return gotils.C(ctx).Errorf("error on GetTokenBuckets: %v", err)
+	}
+	totals, err := db.GetTotals(ctx, from, to, finer)
+	if err != nil {
+		return gotils.C(ctx).Errorf("error on GetTotals: %v", err)
+	}
+
+	err = db.ReplaceRollups(ctx, res, start, backend.MergePairBuckets(pbs, period), backend.MergeTokenBuckets(tbs, period), backend.MergeTotals(totals, period))
+	if err != nil {
+		return gotils.C(ctx).Errorf("error on ReplaceRollups: %v", err)
+	}
+	return nil
+}
+
+// hourSpan returns the start of the earliest hour and the end of the latest one in hours
+func hourSpan(hours map[int64]bool) (time.Time, time.Time) {
+	var from, to int64
+	for t := range hours {
+		if from == 0 || t < from {
+			from = t
+		}
+		if t > to {
+			to = t
+		}
+	}
+	if len(hours) == 0 {
+		return time.Time{}, time.Time{}
+	}
+	return time.Unix(from, 0), time.Unix(to, 0).Add(time.Hour)
+}
@@ -14,11 +14,25 @@ import (
 	"github.com/gochain/gochain/v4/accounts/abi"
 	"github.com/gochain/gochain/v4/common"
 	"github.com/gochain/gochain/v4/core/types"
-	"github.com/gochain/gochain/v4/goclient"
 	"github.com/goswap/stats-api/contracts"
+	"github.com/shopspring/decimal"
 	"github.com/treeder/gotils/v2"
 )
 
+var (
+	// FeeRate is the share of each swap's amountIn that goes to liquidity providers, 0.3% on GoSwap
+	FeeRate = decimal.RequireFromString("0.003")
+)
+
+// ParseFeeRate parses a fee rate like 0.003 for 0.3%, for setting FeeRate
+func ParseFeeRate(s string) (decimal.Decimal, error) {
+	r, err := decimal.NewFromString(strings.TrimSpace(s))
+	if err != nil || r.IsNegative() || !r.LessThan(decimal.NewFromInt(1)) {
+		return decimal.Zero, fmt.Errorf("invalid fee rate %q, must be a fraction of 1 like 0.003", s)
+	}
+	return r, nil
+}
+
 // SwapEvent represents an emitted Swap event
 type SwapEvent struct {
 	TxFrom          common.Address // this user who initiated this transaction, aka: origin
@@ -26,6 +40,7 @@ type SwapEvent struct {
 	To              common.Address
 	BlockNumber     int64
 	TransactionHash string
+	LogIndex        uint
 	Amount0In       *big.Int
 	Amount1In       *big.Int
 	Amount0Out      *big.Int
@@ -35,7 +50,7 @@ type SwapEvent struct {
 
 var swapEventID = common.HexToHash("0xd78ad95fa46c994b6551d0da85fc275fe613ce37657fb8d5e3d130840159d822")
 
-func GetSwapEvents(ctx context.Context, rpc *goclient.Client, pairAddress common.Address, startBlock, endBlock int64) ([]*SwapEvent, error) {
+func GetSwapEvents(ctx context.Context, rpc ChainReader, pairAddress common.Address, startBlock, endBlock int64) ([]*SwapEvent, error) {
 	abi, err := abi.JSON(strings.NewReader(contracts.PairABI))
 	if err != nil {
 		fmt.Println("Failed to parse token Uniswap ABI:", err)
@@ -120,10 +135,11 @@ func unpackSwapEvent(ctx context.Context, abi abi.ABI, event types.Log) (*SwapEv
 	swapEvent.To = common.BytesToAddress(to)
 	swapEvent.BlockNumber = int64(event.BlockNumber)
 	swapEvent.TransactionHash = event.TxHash.String()
+	swapEvent.LogIndex = event.Index
 	return &swapEvent, nil
 }
 
-func GetTimestampByBlockNumber(ctx context.Context, rpc *goclient.Client, blockNumber int64) (time.Time, error) {
+func GetTimestampByBlockNumber(ctx context.Context, rpc ChainReader, blockNumber int64) (time.Time, error) {
 	bln := new(big.Int).SetInt64(blockNumber)
 	block, err := rpc.BlockByNumber(ctx, bln)
 	if err != nil {
@@ -0,0 +1,90 @@
+package collector
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/gochain-io/explorer/server/utils"
+	"github.com/gochain/gochain/v4"
+	"github.com/gochain/gochain/v4/common"
+	"github.com/gochain/gochain/v4/core/types"
+	"github.com/goswap/stats-api/contracts"
+	"github.com/treeder/gotils/v2"
+)
+
+// SyncEvent represents a Sync event, emitted with the new reserves every time they change
+type SyncEvent struct {
+	BlockNumber     int64
+	TransactionHash string
+	LogIndex        uint
+	Reserve0        *big.Int
+	Reserve1        *big.Int
+	Timestamp       time.Time
+}
+
+var syncEventID = common.HexToHash("0x1c411e9a96e071241c2f21f7726b17ae89e3cab4c78be50e062b03a9fffbbad1")
+
+// GetSyncEvents returns the Sync events for the pair in chronological order
+func GetSyncEvents(ctx context.Context, rpc ChainReader, pairAddress common.Address, startBlock, endBlock int64) ([]*SyncEvent, error) {
+	filterer, err := contracts.NewPairFilterer(pairAddress, rpc)
+	if err != nil {
+		return nil, gotils.C(ctx).Errorf("error on NewPairFilterer: %v", err)
+	}
+	ctx = gotils.With(ctx, "address", pairAddress)
+	var syncEvents []*SyncEvent
+	numOfBlocksPerRequest := maxBlockPerRequest
+
+	currentBlock := startBlock
+	for currentBlock <= endBlock {
+		toBlock := currentBlock + numOfBlocksPerRequest
+		if toBlock > endBlock {
+			toBlock = endBlock
+		}
+		fmt.Printf("Querying for sync events, from: %v, to: %v\n",
+			currentBlock, toBlock)
+		query := gochain.FilterQuery{
+			FromBlock: big.NewInt(currentBlock),
+			ToBlock:   big.NewInt(toBlock),
+			Addresses: []common.Address{pairAddress},
+			Topics:    [][]common.Hash{{syncEventID}},
+		}
+
+		var logs []types.Log
+		err := utils.Retry(ctx, 5, 2*time.Second, func() (err error) {
+			logs, err = rpc.FilterLogs(ctx, query)
+			return err
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to query RPC for logs: %v", err)
+		}
+		var currentTimeStamp time.Time
+		var currentBlockNumber int64
+		for _, log := range logs {
+			sync, err := filterer.ParseSync(log)
+			if err != nil {
+				return nil, gotils.C(ctx).Errorf("Failed to unpack event: %v, block: %v, index: %v, contract: %v", err, log.BlockNumber, log.Index, log.Address)
+			}
+			event := &SyncEvent{
+				BlockNumber:     int64(log.BlockNumber),
+				TransactionHash: log.TxHash.String(),
+				LogIndex:        log.Index,
+				Reserve0:        sync.Reserve0,
+				Reserve1:        sync.Reserve1,
+			}
+			syncEvents = append(syncEvents, event)
+
+			if currentBlockNumber != event.BlockNumber || currentTimeStamp.IsZero() {
+				currentTimeStamp, err = GetTimestampByBlockNumber(ctx, rpc, event.BlockNumber)
+				if err != nil {
+					return nil, gotils.C(ctx).Errorf("Failed to get block timestamp: %v", err)
+				}
+			}
+			event.Timestamp = currentTimeStamp
+			currentBlockNumber = event.BlockNumber
+		}
+		currentBlock = toBlock + 1
+	}
+	return syncEvents, nil
+}
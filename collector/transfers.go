@@ -0,0 +1,107 @@
+package collector
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/gochain-io/explorer/server/utils"
+	"github.com/gochain/gochain/v4"
+	"github.com/gochain/gochain/v4/common"
+	"github.com/gochain/gochain/v4/core/types"
+	"github.com/goswap/stats-api/contracts"
+	"github.com/treeder/gotils/v2"
+)
+
+// LPTransferEvent is a Transfer of a pair's LP token that mints or burns it, ie from or to the zero address
+type LPTransferEvent struct {
+	From            common.Address
+	To              common.Address
+	BlockNumber     int64
+	TransactionHash string
+	LogIndex        uint
+	Value           *big.Int
+}
+
+var transferEventID = common.HexToHash("0xddf252ad1be2c89b69c2b068fc378daa952ba7f163c4a11628f55a4df523b3ef")
+
+// GetLPTransferEvents returns the transfers that mint or burn the pair's LP token in chronological order,
+// other transfers are skipped
+func GetLPTransferEvents(ctx context.Context, rpc ChainReader, pairAddress common.Address, startBlock, endBlock int64) ([]*LPTransferEvent, error) {
+	filterer, err := contracts.NewPairFilterer(pairAddress, rpc)
+	if err != nil {
+		return nil, gotils.C(ctx).Errorf("error on NewPairFilterer: %v", err)
+	}
+	ctx = gotils.With(ctx, "address", pairAddress)
+	var transferEvents []*LPTransferEvent
+	numOfBlocksPerRequest := maxBlockPerRequest
+
+	currentBlock := startBlock
+	for currentBlock <= endBlock {
+		toBlock := currentBlock + numOfBlocksPerRequest
+		if toBlock > endBlock {
+			toBlock = endBlock
+		}
+		fmt.Printf("Querying for LP transfer events, from: %v, to: %v\n",
+			currentBlock, toBlock)
+		query := gochain.FilterQuery{
+			FromBlock: big.NewInt(currentBlock),
+			ToBlock:   big.NewInt(toBlock),
+			Addresses: []common.Address{pairAddress},
+			Topics:    [][]common.Hash{{transferEventID}},
+		}
+
+		var logs []types.Log
+		err := utils.Retry(ctx, 5, 2*time.Second, func() (err error) {
+			logs, err = rpc.FilterLogs(ctx, query)
+			return err
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to query RPC for logs: %v", err)
+		}
+		for _, log := range logs {
+			transfer, err := filterer.ParseTransfer(log)
+			if err != nil {
+				return nil, gotils.C(ctx).Errorf("Failed to unpack event: %v, block: %v, index: %v, contract: %v", err, log.BlockNumber, log.Index, log.Address)
+			}
+			if transfer.From != (common.Address{}) && transfer.To != (common.Address{}) {
+				continue
+			}
+			transferEvents = append(transferEvents, &LPTransferEvent{
+				From:            transfer.From,
+				To:              transfer.To,
+				BlockNumber:     int64(log.BlockNumber),
+				TransactionHash: log.TxHash.String(),
+				LogIndex:        log.Index,
+				Value:           transfer.Value,
+			})
+		}
+		currentBlock = toBlock + 1
+	}
+	return transferEvents, nil
+}
+
+// lpMinted returns the LP tokens minted for the Mint event at logIndex in tx. The pair mints them
+// right before emitting Mint, any protocol fee is minted before that, so it's the last mint before it.
+func lpMinted(transfers []*LPTransferEvent, tx string, logIndex uint) (*LPTransferEvent, bool) {
+	var ret *LPTransferEvent
+	for _, t := range transfers {
+		// not the liquidity the first mint locks away at the zero address
+		if t.TransactionHash == tx && t.LogIndex < logIndex && t.From == (common.Address{}) && t.To != (common.Address{}) {
+			ret = t
+		}
+	}
+	return ret, ret != nil
+}
+
+// lpBurned returns the LP tokens burned for the Burn event at logIndex in tx, the last burn before it
+func lpBurned(transfers []*LPTransferEvent, tx string, logIndex uint) (*LPTransferEvent, bool) {
+	var ret *LPTransferEvent
+	for _, t := range transfers {
+		if t.TransactionHash == tx && t.LogIndex < logIndex && t.To == (common.Address{}) && t.From != (common.Address{}) {
+			ret = t
+		}
+	}
+	return ret, ret != nil
+}
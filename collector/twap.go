@@ -0,0 +1,73 @@
+package collector
+
+import (
+	"context"
+	"math/big"
+	"time"
+
+	"github.com/gochain/gochain/v4/accounts/abi/bind"
+	"github.com/goswap/stats-api/models"
+	"github.com/goswap/stats-api/utils"
+	"github.com/shopspring/decimal"
+	"github.com/treeder/gotils/v2"
+)
+
+// cumulativePrices returns the pair's cumulative prices as of blockNumber and that block's time. The
+// contract only updates them on the first change in a block, so they're brought up to the block's time
+// with the reserves since the last update, same as the contract would.
+func cumulativePrices(ctx context.Context, rpc ChainReader, p *models.Pair, blockNumber int64) (*big.Int, *big.Int, time.Time, error) {
+	opts := &bind.CallOpts{Context: ctx, BlockNumber: big.NewInt(blockNumber)}
+	c0, err := p.PairContract.Price0CumulativeLast(opts)
+	if err != nil {
+		return nil, nil, time.Time{}, gotils.C(ctx).Errorf("error on Price0CumulativeLast: %v", err)
+	}
+	c1, err := p.PairContract.Price1CumulativeLast(opts)
+	if err != nil {
+		return nil, nil, time.Time{}, gotils.C(ctx).Errorf("error on Price1CumulativeLast: %v", err)
+	}
+	reserves, err := p.PairContract.GetReserves(opts)
+	if err != nil {
+		return nil, nil, time.Time{}, gotils.C(ctx).Errorf("error on GetReserves: %v", err)
+	}
+	t, err := GetTimestampByBlockNumber(ctx, rpc, blockNumber)
+	if err != nil {
+		return nil, nil, time.Time{}, gotils.C(ctx).Errorf("error getting block time: %v", err)
+	}
+	// the contract keeps timestamps mod 2^32 and lets this overflow too
+	elapsed := uint32(t.Unix()) - reserves.BlockTimestampLast
+	if elapsed > 0 && reserves.Reserve0.Sign() > 0 && reserves.Reserve1.Sign() > 0 {
+		e := new(big.Int).SetUint64(uint64(elapsed))
+		p0 := new(big.Int).Div(new(big.Int).Mul(reserves.Reserve1, utils.Q112), reserves.Reserve0)
+		p1 := new(big.Int).Div(new(big.Int).Mul(reserves.Reserve0, utils.Q112), reserves.Reserve1)
+		c0 = new(big.Int).Add(c0, p0.Mul(p0, e))
+		c1 = new(big.Int).Add(c1, p1.Mul(p1, e))
+	}
+	return c0, c1, t, nil
+}
+
+// addTWAP fills in the bucket's cumulative prices and TWAPs, from the last block before it starts to
+// closeBlock. lo is the first block the pair exists in.
+func addTWAP(ctx context.Context, rpc ChainReader, p *models.Pair, pb *models.PairBucket, lo, closeBlock int64) error {
+	openBlock, err := lastBlockBefore(ctx, rpc, lo, closeBlock, pb.Time)
+	if err != nil {
+		return gotils.C(ctx).Errorf("error finding last block before bucket: %v", err)
+	}
+	if openBlock < lo {
+		openBlock = lo
+	}
+	open0, open1, openAt, err := cumulativePrices(ctx, rpc, p, openBlock)
+	if err != nil {
+		return err
+	}
+	close0, close1, closeAt, err := cumulativePrices(ctx, rpc, p, closeBlock)
+	if err != nil {
+		return err
+	}
+	pb.Price0Cumulative = decimal.NewFromBigInt(close0, 0)
+	pb.Price1Cumulative = decimal.NewFromBigInt(close1, 0)
+	pb.CumulativeAt = closeAt
+	pb.TWAPSeconds = int64(closeAt.Sub(openAt).Seconds())
+	pb.TWAP0 = utils.TWAP(open0, close0, pb.TWAPSeconds, p.Token0.Decimals, p.Token1.Decimals)
+	pb.TWAP1 = utils.TWAP(open1, close1, pb.TWAPSeconds, p.Token1.Decimals, p.Token0.Decimals)
+	return nil
+}
@@ -0,0 +1,120 @@
+package collector
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/goswap/stats-api/backend"
+	"github.com/treeder/gotils/v2"
+)
+
+// Watch runs the collector continuously, every pollInterval it finalizes any hours that have closed with
+// FetchData and then updates the buckets for the hour in progress with UpdatePartial, so they're only ever
+// a poll behind the chain. Runs until ctx is done.
+func Watch(ctx context.Context, rpc ChainReader, db backend.Backend, pollInterval time.Duration) error {
+	for {
+		err := FetchData(ctx, rpc, db)
+		if err != nil {
+			gotils.C(ctx).Printf("error on FetchData, will retry next poll: %v", err)
+		}
+		err = UpdatePartial(ctx, rpc, db)
+		if err != nil {
+			gotils.C(ctx).Printf("error on UpdatePartial, will retry next poll: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(pollInterval):
+		}
+	}
+}
+
+// UpdatePartial recomputes the buckets from each pair's checkpoint up to the latest block, which is the
+// hour in progress (and the one before it, until that's ConfirmationDepth deep). It doesn't move any
+// pair checks, so every call starts over from the same place and FetchData overwrites these buckets with
+// the final ones once the hour closes. That also fixes up any events here that get reorged out.
+func UpdatePartial(ctx context.Context, rpc ChainReader, db backend.Backend) error {
+	truncateBy := time.Hour
+
+	num, err := rpc.LatestBlockNumber(ctx)
+	if err != nil {
+		return gotils.C(ctx).Errorf("failed to get latest block number: %v", err)
+	}
+	headBlock := num.Int64()
+	headTime, err := GetTimestampByBlockNumber(ctx, rpc, headBlock)
+	if err != nil {
+		return gotils.C(ctx).Errorf("%v", err)
+	}
+	hour := headTime.Truncate(truncateBy)
+	stopAt := hour.Add(truncateBy)
+
+	_, err = db.GetTokens(ctx)
+	if err != nil {
+		return gotils.C(ctx).Errorf("error on GetTokens: %v", err)
+	}
+	pairs, err := db.GetPairs(ctx)
+	if err != nil {
+		return gotils.C(ctx).Errorf("error on GetPairs: %v", err)
+	}
+	pairMap, err := setupPairs(ctx, rpc, db, pairs)
+	if err != nil {
+		return gotils.C(ctx).Errorf("error on setupPairs: %v", err)
+	}
+	pairChecks, err := db.GetPairChecks(ctx)
+	if err != nil {
+		return gotils.C(ctx).Errorf("error on GetPairChecks: %v", err)
+	}
+
+	touched := map[int64]bool{}
+	for _, p := range pairs {
+		pc := pairChecks[p.Address.Hex()]
+		if pc == nil || hour.Sub(pc.LastCheckAt) > truncateBy {
+			// FetchData hasn't caught this pair up yet
+			fmt.Printf("%v is behind, skipping partial\n", p.String())
+			continue
+		}
+		if pc.LastBlockNumber >= headBlock {
+			continue
+		}
+		pairBuckets, swaps, liquidityEvents, err := collectPair(ctx, rpc, p, pc.LastBlockNumber+1, headBlock, stopAt, truncateBy)
+		if err != nil {
+			gotils.C(ctx).Printf("error collecting %v, skipping: %v", p.String(), err)
+			continue
+		}
+		err = replaceEvents(ctx, db, p, pc.LastCheckAt, stopAt, swaps, liquidityEvents)
+		if err != nil {
+			return err
+		}
+		for t, pb := range pairBuckets {
+			err = db.SavePairBucket(ctx, pb)
+			if err != nil {
+				return gotils.C(ctx).Errorf("error on SavePairBucket: %v", err)
+			}
+			touched[t] = true
+		}
+	}
+
+	err = rollupBuckets(ctx, db, pairMap, touched)
+	if err != nil {
+		return gotils.C(ctx).Errorf("error on rollupBuckets: %v", err)
+	}
+	from, to := hourSpan(touched)
+	err = rollupPeriods(ctx, db, from, to)
+	if err != nil {
+		return gotils.C(ctx).Errorf("error on rollupPeriods: %v", err)
+	}
+
+	lc, err := db.GetLastCheck(ctx)
+	if err != nil {
+		return gotils.C(ctx).Errorf("error on GetLastCheck: %v", err)
+	}
+	lc.PartialBlockNumber = headBlock
+	lc.PartialAt = headTime
+	err = db.SaveLastCheck(ctx, lc)
+	if err != nil {
+		return gotils.C(ctx).Errorf("error on SaveLastCheck: %v", err)
+	}
+	fmt.Printf("Partial buckets updated to block %v at %v\n", headBlock, headTime)
+	return nil
+}
@@ -8,6 +8,8 @@ require (
 	github.com/go-chi/chi/v5 v5.0.12
 	github.com/gochain-io/explorer v0.4.221
 	github.com/gochain/gochain/v4 v4.2.0
+	github.com/lib/pq v1.10.9
+	github.com/mattn/go-sqlite3 v1.14.22
 	github.com/shopspring/decimal v1.3.1
 	github.com/treeder/firetils v0.0.42
 	github.com/treeder/gcputils v0.1.7
@@ -2,8 +2,11 @@ package models
 
 import (
 	"context"
-	"errors"
 	"fmt"
+	"hash/fnv"
+	"math"
+	"math/big"
+	"sort"
 	"time"
 
 	"github.com/gochain/gochain/v4/accounts/abi/bind"
@@ -25,6 +28,11 @@ type Pair struct {
 	Token0       *Token          `firestore:"-" json:"-"`
 	Token1       *Token          `firestore:"-" json:"-"`
 
+	// from the factory's PairCreated event
+	CreatedBlock  int64     `firestore:"createdBlock" json:"createdBlock"`
+	CreatedAt     time.Time `firestore:"createdAt" json:"createdAt"`
+	CreatedTxHash string    `firestore:"createdTxHash" json:"createdTxHash"`
+
 	// for database
 	AddressHex    string `firestore:"address" json:"address"`
 	Token0Address string `firestore:"token0address" json:"token0"`
@@ -45,18 +53,34 @@ func (td *Pair) String() string {
 	return fmt.Sprintf("%v-%v", td.Token0.Symbol, td.Token1.Symbol)
 }
 
-func (td *Pair) GetReserves(ctx context.Context) (decimal.Decimal, decimal.Decimal, error) {
-	var opts *bind.CallOpts
-	// if blockNumber > 0 {
-	// 	opts = &bind.CallOpts{BlockNumber: big.NewInt(blockNumber)}
-	// }
-	reserves, err := td.PairContract.GetReserves(opts)
+// callOpts returns the options for reading the pair's contract as of blockNumber, 0 for latest
+func (td *Pair) callOpts(ctx context.Context, blockNumber int64) *bind.CallOpts {
+	opts := &bind.CallOpts{Context: ctx}
+	if blockNumber > 0 {
+		opts.BlockNumber = big.NewInt(blockNumber)
+	}
+	return opts
+}
+
+// GetReserves returns the pair's reserves as of blockNumber, 0 for latest. Anything older than the
+// last 128 blocks or so needs an archive node.
+func (td *Pair) GetReserves(ctx context.Context, blockNumber int64) (decimal.Decimal, decimal.Decimal, error) {
+	reserves, err := td.PairContract.GetReserves(td.callOpts(ctx, blockNumber))
 	if err != nil {
 		return decimal.Zero, decimal.Zero, gotils.C(ctx).Errorf("error getting reserves: %v", err)
 	}
 	return utils.IntToDec(reserves.Reserve0, td.Token0.Decimals), utils.IntToDec(reserves.Reserve1, td.Token1.Decimals), nil
 }
 
+// GetTotalSupply returns the supply of the pair's LP token as of blockNumber, 0 for latest
+func (td *Pair) GetTotalSupply(ctx context.Context, blockNumber int64) (decimal.Decimal, error) {
+	totalSupply, err := td.PairContract.TotalSupply(td.callOpts(ctx, blockNumber))
+	if err != nil {
+		return decimal.Zero, gotils.C(ctx).Errorf("error getting total supply: %v", err)
+	}
+	return utils.IntToDec(totalSupply, 18), nil
+}
+
 type PriceNotFound struct {
 	s string
 }
@@ -68,40 +92,36 @@ func (nf *PriceNotFound) Error() string {
 	return "price not found!"
 }
 
-// PriceInUSD only call this on a USDC pair
-func (td *Pair) PriceInUSD(ctx context.Context) (decimal.Decimal, error) {
+// PriceIn returns the price of the pair's other token in terms of quote, which has to be one of
+// the pair's tokens, as of blockNumber (0 for latest). Tokens are matched by address, not symbol,
+// since anyone can call a token USDC.
+func (td *Pair) PriceIn(ctx context.Context, quote common.Address, blockNumber int64) (decimal.Decimal, error) {
 	// calc is getReserves()
-	// USDC reserve, shifted token.decimals over (6)
+	// quote reserve, shifted token.decimals over (6 for USDC)
 	// divided by token reserve shifted token.decimals over
 	// that will give us the correct amount
-	var opts *bind.CallOpts
-	// if blockNumber > 0 {
-	// 	opts = &bind.CallOpts{BlockNumber: big.NewInt(blockNumber)}
-	// }
-	reserves, err := td.PairContract.GetReserves(opts)
+	reserves, err := td.PairContract.GetReserves(td.callOpts(ctx, blockNumber))
 	if err != nil {
 		return decimal.Zero, gotils.C(ctx).Errorf("error getting reserves: %v", err)
 	}
 	var other *Token
-	var usdcReserve, otherReserve decimal.Decimal
-	if td.Token0.Symbol == "USDC" {
-		// usdc = td.Token0
+	var quoteReserve, otherReserve decimal.Decimal
+	if td.Token0.Address == quote {
 		other = td.Token1
-		usdcReserve = utils.IntToDec(reserves.Reserve0, td.Token0.Decimals)
+		quoteReserve = utils.IntToDec(reserves.Reserve0, td.Token0.Decimals)
 		otherReserve = utils.IntToDec(reserves.Reserve1, td.Token1.Decimals)
-	} else if td.Token1.Symbol == "USDC" {
-		// usdc = td.Token1
+	} else if td.Token1.Address == quote {
 		other = td.Token0
-		usdcReserve = utils.IntToDec(reserves.Reserve1, td.Token1.Decimals)
+		quoteReserve = utils.IntToDec(reserves.Reserve1, td.Token1.Decimals)
 		otherReserve = utils.IntToDec(reserves.Reserve0, td.Token0.Decimals)
 	} else {
-		return decimal.Zero, errors.New("NO USDC IN PAIR")
+		return decimal.Zero, fmt.Errorf("%v not in pair %v", quote.Hex(), td.String())
 	}
-	if usdcReserve.LessThan(decimal.NewFromInt(10)) {
+	if quoteReserve.LessThan(decimal.NewFromInt(10)) {
 		gotils.C(ctx).Printf("%v Liquidity too low in pricing pair, returning zero", other.Symbol)
 		return decimal.Zero, nil
 	}
-	return usdcReserve.Div(otherReserve), nil
+	return quoteReserve.Div(otherReserve), nil
 }
 
 // Token represents an ERC20
@@ -135,6 +155,23 @@ func (td *Token) String() string {
 	return fmt.Sprintf("%v", td.Symbol)
 }
 
+// SymbolCollisions returns the addresses of tokens that share their symbol with another token, by
+// symbol. Symbols aren't unique, so these can't be told apart by symbol alone.
+func SymbolCollisions(tokens []*Token) map[string][]string {
+	bySymbol := map[string][]string{}
+	for _, t := range tokens {
+		bySymbol[t.Symbol] = append(bySymbol[t.Symbol], t.Address.Hex())
+	}
+	collisions := map[string][]string{}
+	for symbol, addresses := range bySymbol {
+		if len(addresses) > 1 {
+			sort.Strings(addresses)
+			collisions[symbol] = addresses
+		}
+	}
+	return collisions
+}
+
 type PairLiquidity struct {
 	// Address is the ID of the pair
 	Address string `firestore:"address" json:"address"`
@@ -216,6 +253,81 @@ func (s *PairLiquidity) ValUSD() decimal.Decimal {
 // 	pb.Price, _ = decimal.NewFromString(pb.PriceS)
 // }
 
+// OHLC is the open, high, low and close of a price over a bucket, all zero if there weren't any prices
+type OHLC struct {
+	Open  decimal.Decimal `json:"open"`
+	High  decimal.Decimal `json:"high"`
+	Low   decimal.Decimal `json:"low"`
+	Close decimal.Decimal `json:"close"`
+}
+
+// IsZero returns whether the candle has no prices
+func (c OHLC) IsZero() bool {
+	return c.Open.IsZero()
+}
+
+// Observe adds the next price to the candle, non-positive prices are skipped
+func (c *OHLC) Observe(price decimal.Decimal) {
+	if !price.IsPositive() {
+		return
+	}
+	if c.IsZero() {
+		*c = OHLC{Open: price, High: price, Low: price, Close: price}
+		return
+	}
+	c.High = decimal.Max(c.High, price)
+	c.Low = decimal.Min(c.Low, price)
+	c.Close = price
+}
+
+// Merge returns the candle over this one's time and then later's, for rolling buckets up
+func (c OHLC) Merge(later OHLC) OHLC {
+	if c.IsZero() {
+		return later
+	}
+	if later.IsZero() {
+		return c
+	}
+	return OHLC{Open: c.Open, High: decimal.Max(c.High, later.High), Low: decimal.Min(c.Low, later.Low), Close: later.Close}
+}
+
+// Mul returns the candle with every price multiplied by d, eg to turn it into USD
+func (c OHLC) Mul(d decimal.Decimal) OHLC {
+	return OHLC{Open: c.Open.Mul(d), High: c.High.Mul(d), Low: c.Low.Mul(d), Close: c.Close.Mul(d)}
+}
+
+// Inverse returns the candle of the other token of a pair, ie token1 in token0 from token0 in
+// token1, so the high and low swap places
+func (c OHLC) Inverse() OHLC {
+	if c.IsZero() {
+		return c
+	}
+	inv := func(d decimal.Decimal) decimal.Decimal { return decimal.NewFromInt(1).DivRound(d, 18) }
+	return OHLC{Open: inv(c.Open), High: inv(c.Low), Low: inv(c.High), Close: inv(c.Close)}
+}
+
+// strings returns the prices as strings, for firebase
+func (c OHLC) strings() (open, high, low, close string) {
+	return c.Open.String(), c.High.String(), c.Low.String(), c.Close.String()
+}
+
+// parseOHLC is the opposite of strings
+func parseOHLC(open, high, low, close string) OHLC {
+	var c OHLC
+	c.Open, _ = decimal.NewFromString(open)
+	c.High, _ = decimal.NewFromString(high)
+	c.Low, _ = decimal.NewFromString(low)
+	c.Close, _ = decimal.NewFromString(close)
+	return c
+}
+
+// Candle is a bucket's candle for charts, with the bucket's volume
+type Candle struct {
+	Time time.Time `json:"time"`
+	OHLC
+	VolumeUSD decimal.Decimal `json:"volumeUSD"`
+}
+
 type PairBucket struct {
 	// Address is the ID of the pair
 	Address string `firestore:"address" json:"address"`
@@ -230,6 +342,28 @@ type PairBucket struct {
 	Price0USD  decimal.Decimal `firestore:"-" json:"price0USD"`
 	Price1USD  decimal.Decimal `firestore:"-" json:"price1USD"`
 	VolumeUSD  decimal.Decimal `firestore:"-" json:"volumeUSD"` // in USD
+	// the share of the volume paid to liquidity providers, at the collector's FeeRate
+	FeesUSD decimal.Decimal `firestore:"-" json:"feesUSD"`
+	// feesUSD annualized over the average liquidityUSD, only set by /v1/stats/pairs
+	FeeAPR decimal.Decimal `firestore:"-" json:"feeAPR"`
+
+	// number of Swap events and how many different accounts sent them
+	SwapCount     int `firestore:"swapCount" json:"swapCount"`
+	UniqueTraders int `firestore:"uniqueTraders" json:"uniqueTraders"`
+	// the accounts that swapped, kept so unique traders can be counted when rolling up. Capped at
+	// MaxTraders, see UnionTraders.
+	Traders []string `firestore:"traders" json:"-"`
+
+	// liquidity added via Mint events:
+	LiquidityAdded0   decimal.Decimal `firestore:"-" json:"liquidityAdded0"`
+	LiquidityAdded1   decimal.Decimal `firestore:"-" json:"liquidityAdded1"`
+	LiquidityAddedUSD decimal.Decimal `firestore:"-" json:"liquidityAddedUSD"`
+	MintCount         int             `firestore:"mintCount" json:"mintCount"`
+
+	// liquidity removed via Burn events:
+	LiquidityRemoved0   decimal.Decimal `firestore:"-" json:"liquidityRemoved0"`
+	LiquidityRemoved1   decimal.Decimal `firestore:"-" json:"liquidityRemoved1"`
+	LiquidityRemovedUSD decimal.Decimal `firestore:"-" json:"liquidityRemovedUSD"`
 
 	// liquidity stuff:
 	TotalSupply  decimal.Decimal `firestore:"-" json:"totalSupply"`
@@ -237,6 +371,22 @@ type PairBucket struct {
 	Reserve1     decimal.Decimal `firestore:"-" json:"reserve1"`
 	LiquidityUSD decimal.Decimal `firestore:"-" json:"liquidityUSD"` // not stored, but returned in API
 
+	// time weighted average prices over the bucket from the pair's cumulative prices, token0 in token1
+	// and token1 in token0. TWAPSeconds is how long they're over, for weighting when rolling up.
+	TWAP0       decimal.Decimal `firestore:"-" json:"twap0"`
+	TWAP1       decimal.Decimal `firestore:"-" json:"twap1"`
+	TWAPSeconds int64           `firestore:"twapSeconds" json:"-"`
+
+	// the pair's price0CumulativeLast and price1CumulativeLast at the last block in the bucket, brought up
+	// to that block's time (CumulativeAt) like the contract does. These are raw UQ112x112 numbers.
+	Price0Cumulative decimal.Decimal `firestore:"-" json:"price0Cumulative"`
+	Price1Cumulative decimal.Decimal `firestore:"-" json:"price1Cumulative"`
+	CumulativeAt     time.Time       `firestore:"cumulativeAt" json:"cumulativeAt"`
+
+	// candle of the price of token0 in token1 from the execution price of each swap and the spot price
+	// after each Sync, in chain order. Hours with neither are flat at the spot price of the reserves.
+	OHLC0 OHLC `firestore:"-" json:"ohlc0"`
+
 	// For firebase
 	Amount0InS  string `firestore:"amount0In" json:"-"`
 	Amount1InS  string `firestore:"amount1In" json:"-"`
@@ -245,10 +395,29 @@ type PairBucket struct {
 	Price0USDS  string `firestore:"price0USD" json:"-"`
 	Price1USDS  string `firestore:"price1USD" json:"-"`
 	VolumeUSDS  string `firestore:"volumeUSD" json:"-"`
+	FeesUSDS    string `firestore:"feesUSD" json:"-"`
+
+	LiquidityAdded0S   string `firestore:"liquidityAdded0" json:"-"`
+	LiquidityAdded1S   string `firestore:"liquidityAdded1" json:"-"`
+	LiquidityAddedUSDS string `firestore:"liquidityAddedUSD" json:"-"`
+
+	LiquidityRemoved0S   string `firestore:"liquidityRemoved0" json:"-"`
+	LiquidityRemoved1S   string `firestore:"liquidityRemoved1" json:"-"`
+	LiquidityRemovedUSDS string `firestore:"liquidityRemovedUSD" json:"-"`
 
 	TotalSupplyS string `firestore:"totalSupply" json:"-"`
 	Reserve0S    string `firestore:"reserve0" json:"-"`
 	Reserve1S    string `firestore:"reserve1" json:"-"`
+
+	TWAP0S            string `firestore:"twap0" json:"-"`
+	TWAP1S            string `firestore:"twap1" json:"-"`
+	Price0CumulativeS string `firestore:"price0Cumulative" json:"-"`
+	Price1CumulativeS string `firestore:"price1Cumulative" json:"-"`
+
+	Open0S  string `firestore:"open0" json:"-"`
+	High0S  string `firestore:"high0" json:"-"`
+	Low0S   string `firestore:"low0" json:"-"`
+	Close0S string `firestore:"close0" json:"-"`
 }
 
 // PreSave Need these annoying things because firebase doesn't handle things properly
@@ -260,11 +429,26 @@ func (pb *PairBucket) PreSave() {
 	pb.Price0USDS = pb.Price0USD.String()
 	pb.Price1USDS = pb.Price1USD.String()
 	pb.VolumeUSDS = pb.VolumeUSD.String()
+	pb.FeesUSDS = pb.FeesUSD.String()
+
+	pb.LiquidityAdded0S = pb.LiquidityAdded0.String()
+	pb.LiquidityAdded1S = pb.LiquidityAdded1.String()
+	pb.LiquidityAddedUSDS = pb.LiquidityAddedUSD.String()
+
+	pb.LiquidityRemoved0S = pb.LiquidityRemoved0.String()
+	pb.LiquidityRemoved1S = pb.LiquidityRemoved1.String()
+	pb.LiquidityRemovedUSDS = pb.LiquidityRemovedUSD.String()
 
 	pb.TotalSupplyS = pb.TotalSupply.String()
 	pb.Reserve0S = pb.Reserve0.String()
 	pb.Reserve1S = pb.Reserve1.String()
 
+	pb.TWAP0S = pb.TWAP0.String()
+	pb.TWAP1S = pb.TWAP1.String()
+	pb.Price0CumulativeS = pb.Price0Cumulative.String()
+	pb.Price1CumulativeS = pb.Price1Cumulative.String()
+
+	pb.Open0S, pb.High0S, pb.Low0S, pb.Close0S = pb.OHLC0.strings()
 }
 func (pb *PairBucket) AfterLoad(ctx context.Context) {
 	// t.Ref = ref
@@ -276,14 +460,128 @@ func (pb *PairBucket) AfterLoad(ctx context.Context) {
 	pb.Price0USD, _ = decimal.NewFromString(pb.Price0USDS)
 	pb.Price1USD, _ = decimal.NewFromString(pb.Price1USDS)
 	pb.VolumeUSD, _ = decimal.NewFromString(pb.VolumeUSDS)
+	pb.FeesUSD, _ = decimal.NewFromString(pb.FeesUSDS)
+
+	pb.LiquidityAdded0, _ = decimal.NewFromString(pb.LiquidityAdded0S)
+	pb.LiquidityAdded1, _ = decimal.NewFromString(pb.LiquidityAdded1S)
+	pb.LiquidityAddedUSD, _ = decimal.NewFromString(pb.LiquidityAddedUSDS)
+
+	pb.LiquidityRemoved0, _ = decimal.NewFromString(pb.LiquidityRemoved0S)
+	pb.LiquidityRemoved1, _ = decimal.NewFromString(pb.LiquidityRemoved1S)
+	pb.LiquidityRemovedUSD, _ = decimal.NewFromString(pb.LiquidityRemovedUSDS)
 
 	pb.Reserve0, _ = decimal.NewFromString(pb.Reserve0S)
 	pb.Reserve1, _ = decimal.NewFromString(pb.Reserve1S)
 	pb.TotalSupply, _ = decimal.NewFromString(pb.TotalSupplyS)
 
+	pb.TWAP0, _ = decimal.NewFromString(pb.TWAP0S)
+	pb.TWAP1, _ = decimal.NewFromString(pb.TWAP1S)
+	pb.Price0Cumulative, _ = decimal.NewFromString(pb.Price0CumulativeS)
+	pb.Price1Cumulative, _ = decimal.NewFromString(pb.Price1CumulativeS)
+
+	pb.OHLC0 = parseOHLC(pb.Open0S, pb.High0S, pb.Low0S, pb.Close0S)
+
 	pb.LiquidityUSD = pb.Reserve0.Mul(pb.Price0USD).Add(pb.Reserve1.Mul(pb.Price1USD))
 }
 
+// AddFlows adds the amounts, volume, fees and liquidity events of another bucket into this one, for rolling
+// buckets up. Prices and reserves are left alone, see Add.
+func (pb *PairBucket) AddFlows(p *PairBucket) {
+	pb.Amount0In = pb.Amount0In.Add(p.Amount0In)
+	pb.Amount1In = pb.Amount1In.Add(p.Amount1In)
+	pb.Amount0Out = pb.Amount0Out.Add(p.Amount0Out)
+	pb.Amount1Out = pb.Amount1Out.Add(p.Amount1Out)
+	pb.VolumeUSD = pb.VolumeUSD.Add(p.VolumeUSD)
+	pb.FeesUSD = pb.FeesUSD.Add(p.FeesUSD)
+	pb.SwapCount += p.SwapCount
+	pb.AddTraders(p.Traders...)
+	pb.LiquidityAdded0 = pb.LiquidityAdded0.Add(p.LiquidityAdded0)
+	pb.LiquidityAdded1 = pb.LiquidityAdded1.Add(p.LiquidityAdded1)
+	pb.LiquidityAddedUSD = pb.LiquidityAddedUSD.Add(p.LiquidityAddedUSD)
+	pb.MintCount += p.MintCount
+	pb.LiquidityRemoved0 = pb.LiquidityRemoved0.Add(p.LiquidityRemoved0)
+	pb.LiquidityRemoved1 = pb.LiquidityRemoved1.Add(p.LiquidityRemoved1)
+	pb.LiquidityRemovedUSD = pb.LiquidityRemovedUSD.Add(p.LiquidityRemovedUSD)
+	pb.AddTWAP(p)
+}
+
+// Add rolls a later bucket into this one, flows are added, candles are combined and prices and
+// liquidity are taken from p since they're as of the end of the bucket
+func (pb *PairBucket) Add(p *PairBucket) {
+	pb.AddFlows(p)
+	pb.OHLC0 = pb.OHLC0.Merge(p.OHLC0)
+	pb.Price0USD = p.Price0USD
+	pb.Price1USD = p.Price1USD
+	pb.TotalSupply = p.TotalSupply
+	pb.Reserve0 = p.Reserve0
+	pb.Reserve1 = p.Reserve1
+	pb.LiquidityUSD = p.LiquidityUSD
+	pb.Price0Cumulative = p.Price0Cumulative
+	pb.Price1Cumulative = p.Price1Cumulative
+	pb.CumulativeAt = p.CumulativeAt
+}
+
+// Empty returns a bucket at t with no flows that keeps this one's prices and liquidity, for an
+// interval after it with nothing going on
+func (pb *PairBucket) Empty(t time.Time) *PairBucket {
+	return &PairBucket{
+		Address:          pb.Address,
+		Pair:             pb.Pair,
+		Time:             t,
+		Price0USD:        pb.Price0USD,
+		Price1USD:        pb.Price1USD,
+		TotalSupply:      pb.TotalSupply,
+		Reserve0:         pb.Reserve0,
+		Reserve1:         pb.Reserve1,
+		LiquidityUSD:     pb.LiquidityUSD,
+		Price0Cumulative: pb.Price0Cumulative,
+		Price1Cumulative: pb.Price1Cumulative,
+		CumulativeAt:     pb.CumulativeAt,
+		OHLC0:            flat(pb.OHLC0.Close),
+	}
+}
+
+// flat returns a candle that stayed at price the whole time
+func flat(price decimal.Decimal) OHLC {
+	return OHLC{Open: price, High: price, Low: price, Close: price}
+}
+
+// AddTraders adds accounts that swapped to the bucket's distinct traders
+func (pb *PairBucket) AddTraders(traders ...string) {
+	pb.Traders = UnionTraders(pb.Traders, traders...)
+	pb.UniqueTraders = CountTraders(pb.Traders)
+}
+
+// AddTWAP combines the TWAPs of another bucket into this one, weighted by how long each is over,
+// for rolling buckets up
+func (pb *PairBucket) AddTWAP(p *PairBucket) {
+	secs := pb.TWAPSeconds + p.TWAPSeconds
+	if secs == 0 {
+		return
+	}
+	w0 := decimal.NewFromInt(pb.TWAPSeconds)
+	w1 := decimal.NewFromInt(p.TWAPSeconds)
+	total := decimal.NewFromInt(secs)
+	pb.TWAP0 = pb.TWAP0.Mul(w0).Add(p.TWAP0.Mul(w1)).DivRound(total, 18)
+	pb.TWAP1 = pb.TWAP1.Mul(w0).Add(p.TWAP1.Mul(w1)).DivRound(total, 18)
+	pb.TWAPSeconds = secs
+}
+
+// PairTWAP is the time weighted average prices of a pair between two times
+type PairTWAP struct {
+	Address string          `json:"address"`
+	Pair    string          `json:"pair"`
+	From    time.Time       `json:"from"`
+	To      time.Time       `json:"to"`
+	TWAP0   decimal.Decimal `json:"twap0"`
+	TWAP1   decimal.Decimal `json:"twap1"`
+}
+
+// Candle returns the bucket's candle for charts
+func (pb *PairBucket) Candle() *Candle {
+	return &Candle{Time: pb.Time, OHLC: pb.OHLC0, VolumeUSD: pb.VolumeUSD}
+}
+
 func (s *PairBucket) ValUSD() decimal.Decimal {
 	reserve0val := s.Reserve0.Mul(s.Price0USD)
 	reserve1val := s.Reserve1.Mul(s.Price1USD)
@@ -302,17 +600,47 @@ type TokenBucket struct {
 	AmountOut decimal.Decimal `firestore:"-" json:"amountOut"`
 	PriceUSD  decimal.Decimal `firestore:"-" json:"priceUSD"`
 	VolumeUSD decimal.Decimal `firestore:"-" json:"volumeUSD"`
+	// fees paid in the token, across all pairs
+	FeesUSD decimal.Decimal `firestore:"-" json:"feesUSD"`
+
+	// swaps in all the token's pairs and how many different accounts sent them
+	SwapCount     int      `firestore:"swapCount" json:"swapCount"`
+	UniqueTraders int      `firestore:"uniqueTraders" json:"uniqueTraders"`
+	Traders       []string `firestore:"traders" json:"-"`
+
+	// liquidity added via Mint events, across all pairs
+	LiquidityAdded    decimal.Decimal `firestore:"-" json:"liquidityAdded"`
+	LiquidityAddedUSD decimal.Decimal `firestore:"-" json:"liquidityAddedUSD"`
+	MintCount         int             `firestore:"mintCount" json:"mintCount"`
+
+	// liquidity removed via Burn events, across all pairs
+	LiquidityRemoved    decimal.Decimal `firestore:"-" json:"liquidityRemoved"`
+	LiquidityRemovedUSD decimal.Decimal `firestore:"-" json:"liquidityRemovedUSD"`
 
 	// liquidity
 	Reserve      decimal.Decimal `firestore:"-" json:"reserve"`
 	LiquidityUSD decimal.Decimal `firestore:"-" json:"liquidityUSD"` // not stored, but returned in API
 
+	// candle of the price in USD, from the candle of the token's most liquid pair in each hour
+	OHLCUSD OHLC `firestore:"-" json:"ohlcUSD"`
+
 	// firebase bullshit:
 	AmountInS  string `firestore:"amountIn" json:"-"`
 	AmountOutS string `firestore:"amountOut" json:"-"`
 	PriceUSDS  string `firestore:"priceUSD" json:"-"`
 	VolumeUSDS string `firestore:"volumeUSD" json:"-"`
+	FeesUSDS   string `firestore:"feesUSD" json:"-"`
 	ReserveS   string `firestore:"reserve" json:"-"`
+
+	LiquidityAddedS      string `firestore:"liquidityAdded" json:"-"`
+	LiquidityAddedUSDS   string `firestore:"liquidityAddedUSD" json:"-"`
+	LiquidityRemovedS    string `firestore:"liquidityRemoved" json:"-"`
+	LiquidityRemovedUSDS string `firestore:"liquidityRemovedUSD" json:"-"`
+
+	OpenUSDS  string `firestore:"openUSD" json:"-"`
+	HighUSDS  string `firestore:"highUSD" json:"-"`
+	LowUSDS   string `firestore:"lowUSD" json:"-"`
+	CloseUSDS string `firestore:"closeUSD" json:"-"`
 }
 
 // PreSave Need these annoying things because firebase doesn't handle things properly
@@ -324,7 +652,14 @@ func (pb *TokenBucket) PreSave() {
 
 	pb.PriceUSDS = pb.PriceUSD.String()
 	pb.VolumeUSDS = pb.VolumeUSD.String()
+	pb.FeesUSDS = pb.FeesUSD.String()
+
+	pb.LiquidityAddedS = pb.LiquidityAdded.String()
+	pb.LiquidityAddedUSDS = pb.LiquidityAddedUSD.String()
+	pb.LiquidityRemovedS = pb.LiquidityRemoved.String()
+	pb.LiquidityRemovedUSDS = pb.LiquidityRemovedUSD.String()
 
+	pb.OpenUSDS, pb.HighUSDS, pb.LowUSDS, pb.CloseUSDS = pb.OHLCUSD.strings()
 }
 func (pb *TokenBucket) AfterLoad(ctx context.Context) {
 	// t.Ref = ref
@@ -336,6 +671,14 @@ func (pb *TokenBucket) AfterLoad(ctx context.Context) {
 
 	pb.PriceUSD, _ = decimal.NewFromString(pb.PriceUSDS)
 	pb.VolumeUSD, _ = decimal.NewFromString(pb.VolumeUSDS)
+	pb.FeesUSD, _ = decimal.NewFromString(pb.FeesUSDS)
+
+	pb.LiquidityAdded, _ = decimal.NewFromString(pb.LiquidityAddedS)
+	pb.LiquidityAddedUSD, _ = decimal.NewFromString(pb.LiquidityAddedUSDS)
+	pb.LiquidityRemoved, _ = decimal.NewFromString(pb.LiquidityRemovedS)
+	pb.LiquidityRemovedUSD, _ = decimal.NewFromString(pb.LiquidityRemovedUSDS)
+
+	pb.OHLCUSD = parseOHLC(pb.OpenUSDS, pb.HighUSDS, pb.LowUSDS, pb.CloseUSDS)
 
 	pb.LiquidityUSD = pb.Reserve.Mul(pb.PriceUSD)
 }
@@ -345,20 +688,159 @@ func (s *TokenBucket) TokenLiquidity() decimal.Decimal {
 	return reserve0val
 }
 
+// AddFlows adds the amounts, volume, fees and liquidity events of another bucket into this one, for rolling
+// buckets up. Price and reserve are left alone, see Add.
+func (tb *TokenBucket) AddFlows(t *TokenBucket) {
+	tb.AmountIn = tb.AmountIn.Add(t.AmountIn)
+	tb.AmountOut = tb.AmountOut.Add(t.AmountOut)
+	tb.VolumeUSD = tb.VolumeUSD.Add(t.VolumeUSD)
+	tb.FeesUSD = tb.FeesUSD.Add(t.FeesUSD)
+	tb.SwapCount += t.SwapCount
+	tb.AddTraders(t.Traders...)
+	tb.LiquidityAdded = tb.LiquidityAdded.Add(t.LiquidityAdded)
+	tb.LiquidityAddedUSD = tb.LiquidityAddedUSD.Add(t.LiquidityAddedUSD)
+	tb.MintCount += t.MintCount
+	tb.LiquidityRemoved = tb.LiquidityRemoved.Add(t.LiquidityRemoved)
+	tb.LiquidityRemovedUSD = tb.LiquidityRemovedUSD.Add(t.LiquidityRemovedUSD)
+}
+
+// AddTraders adds accounts that swapped to the bucket's distinct traders
+func (tb *TokenBucket) AddTraders(traders ...string) {
+	tb.Traders = UnionTraders(tb.Traders, traders...)
+	tb.UniqueTraders = CountTraders(tb.Traders)
+}
+
+// Add rolls a later bucket into this one, flows are added, candles are combined and price and
+// reserve are taken from t since they're as of the end of the bucket
+func (tb *TokenBucket) Add(t *TokenBucket) {
+	tb.AddFlows(t)
+	tb.OHLCUSD = tb.OHLCUSD.Merge(t.OHLCUSD)
+	tb.PriceUSD = t.PriceUSD
+	tb.Reserve = t.Reserve
+	tb.LiquidityUSD = t.LiquidityUSD
+}
+
+// Empty returns a bucket at t with no flows that keeps this one's price and liquidity, like
+// PairBucket.Empty
+func (tb *TokenBucket) Empty(t time.Time) *TokenBucket {
+	return &TokenBucket{
+		Address:      tb.Address,
+		Symbol:       tb.Symbol,
+		Time:         t,
+		PriceUSD:     tb.PriceUSD,
+		Reserve:      tb.Reserve,
+		LiquidityUSD: tb.LiquidityUSD,
+		OHLCUSD:      flat(tb.OHLCUSD.Close),
+	}
+}
+
+// Candle returns the bucket's candle in USD for charts
+func (tb *TokenBucket) Candle() *Candle {
+	return &Candle{Time: tb.Time, OHLC: tb.OHLCUSD, VolumeUSD: tb.VolumeUSD}
+}
+
 type TotalBucket struct {
 	Time time.Time `firestore:"time"`
 
 	VolumeUSD    decimal.Decimal `firestore:"-" json:"volumeUSD"`    // in USD
+	FeesUSD      decimal.Decimal `firestore:"-" json:"feesUSD"`      // in USD
 	LiquidityUSD decimal.Decimal `firestore:"-" json:"liquidityUSD"` // in USD
 
+	// swaps in all pairs and how many different accounts sent them
+	SwapCount     int      `firestore:"swapCount" json:"swapCount"`
+	UniqueTraders int      `firestore:"uniqueTraders" json:"uniqueTraders"`
+	Traders       []string `firestore:"traders" json:"-"`
+
 	// fireabase :(
 	VolumeUSDS    string `firestore:"volumeUSD" json:"-"`
+	FeesUSDS      string `firestore:"feesUSD" json:"-"`
 	LiquidityUSDS string `firestore:"liquidityUSD" json:"-"`
 }
 
+// AddFlows adds another bucket's volume, fees and swaps to this one, for rolling buckets up
+func (pb *TotalBucket) AddFlows(t *TotalBucket) {
+	pb.VolumeUSD = pb.VolumeUSD.Add(t.VolumeUSD)
+	pb.FeesUSD = pb.FeesUSD.Add(t.FeesUSD)
+	pb.SwapCount += t.SwapCount
+	pb.AddTraders(t.Traders...)
+}
+
+// Add rolls a later bucket into this one, flows are added and liquidity is taken from t
+func (pb *TotalBucket) Add(t *TotalBucket) {
+	pb.AddFlows(t)
+	pb.LiquidityUSD = t.LiquidityUSD
+}
+
+// AddTraders adds accounts that swapped to the bucket's distinct traders
+func (pb *TotalBucket) AddTraders(traders ...string) {
+	pb.Traders = UnionTraders(pb.Traders, traders...)
+	pb.UniqueTraders = CountTraders(pb.Traders)
+}
+
+// MaxTraders is how many accounts a bucket keeps in Traders. Day and week rollups can have far
+// more traders than fit in a firestore document (1 MiB), so past this the list becomes a
+// k-minimum-values sketch: only the accounts with the smallest hashes are kept and
+// CountTraders estimates the rest from them, to within about 1%.
+const MaxTraders = 10000
+
+// UnionTraders returns the distinct accounts in a and b, sorted. Empty ones are skipped. If there are
+// more than MaxTraders, only the MaxTraders with the smallest hashes are kept, so the result is the
+// same whichever order buckets are rolled up in.
+func UnionTraders(a []string, b ...string) []string {
+	set := make(map[string]bool, len(a)+len(b))
+	for _, t := range a {
+		set[t] = true
+	}
+	for _, t := range b {
+		set[t] = true
+	}
+	delete(set, "")
+	if len(set) == 0 {
+		return nil
+	}
+	ret := make([]string, 0, len(set))
+	for t := range set {
+		ret = append(ret, t)
+	}
+	if len(ret) > MaxTraders {
+		sort.Slice(ret, func(i, j int) bool { return traderHash(ret[i]) < traderHash(ret[j]) })
+		ret = ret[:MaxTraders]
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+// CountTraders returns how many distinct accounts traders, as returned by UnionTraders, stands for.
+// This is exact up to MaxTraders and an estimate past it.
+func CountTraders(traders []string) int {
+	if len(traders) < MaxTraders {
+		return len(traders)
+	}
+	// the kth smallest of n uniform hashes is around k/n of the way through the hash space
+	var max uint64
+	for _, t := range traders {
+		if h := traderHash(t); h > max {
+			max = h
+		}
+	}
+	return int(float64(MaxTraders-1) / (float64(max) / math.MaxUint64))
+}
+
+func traderHash(trader string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(trader))
+	return h.Sum64()
+}
+
+// Empty returns a bucket at t with no volume that keeps this one's liquidity
+func (pb *TotalBucket) Empty(t time.Time) *TotalBucket {
+	return &TotalBucket{Time: t, LiquidityUSD: pb.LiquidityUSD}
+}
+
 // PreSave Need these annoying things because firebase doesn't handle things properly
 func (pb *TotalBucket) PreSave() {
 	pb.VolumeUSDS = pb.VolumeUSD.String()
+	pb.FeesUSDS = pb.FeesUSD.String()
 	pb.LiquidityUSDS = pb.LiquidityUSD.String()
 
 }
@@ -366,5 +848,144 @@ func (pb *TotalBucket) AfterLoad(ctx context.Context) {
 	// t.Ref = ref
 	// t.ID = t.Ref.ID
 	pb.VolumeUSD, _ = decimal.NewFromString(pb.VolumeUSDS)
+	pb.FeesUSD, _ = decimal.NewFromString(pb.FeesUSDS)
 	pb.LiquidityUSD, _ = decimal.NewFromString(pb.LiquidityUSDS)
 }
+
+// Swap is a single Swap event on a pair, kept so trades can be listed one by one
+type Swap struct {
+	// Address is the pair's address
+	Address string `firestore:"address" json:"address"`
+	Pair    string `firestore:"pair" json:"pair"`
+	Token0  string `firestore:"token0" json:"token0"`
+	Token1  string `firestore:"token1" json:"token1"`
+
+	TxHash      string    `firestore:"txHash" json:"txHash"`
+	LogIndex    int64     `firestore:"logIndex" json:"logIndex"`
+	BlockNumber int64     `firestore:"blockNumber" json:"blockNumber"`
+	Time        time.Time `firestore:"time" json:"time"`
+
+	// From is who called the pair, typically the router. To got the tokens out and TxFrom sent the
+	// transaction.
+	From   string `firestore:"from" json:"from"`
+	To     string `firestore:"to" json:"to"`
+	TxFrom string `firestore:"txFrom" json:"txFrom"`
+
+	Amount0In  decimal.Decimal `firestore:"-" json:"amount0In"`
+	Amount1In  decimal.Decimal `firestore:"-" json:"amount1In"`
+	Amount0Out decimal.Decimal `firestore:"-" json:"amount0Out"`
+	Amount1Out decimal.Decimal `firestore:"-" json:"amount1Out"`
+	ValueUSD   decimal.Decimal `firestore:"-" json:"valueUSD"` // what went in, at the hour's prices
+
+	// for firestore, which can only match one of several fields with array-contains
+	Tokens  []string `firestore:"tokens" json:"-"`
+	Wallets []string `firestore:"wallets" json:"-"`
+
+	Amount0InS  string `firestore:"amount0In" json:"-"`
+	Amount1InS  string `firestore:"amount1In" json:"-"`
+	Amount0OutS string `firestore:"amount0Out" json:"-"`
+	Amount1OutS string `firestore:"amount1Out" json:"-"`
+	ValueUSDS   string `firestore:"valueUSD" json:"-"`
+}
+
+// PreSave Need these annoying things because firebase doesn't handle things properly
+func (s *Swap) PreSave() {
+	s.Tokens = []string{s.Token0, s.Token1}
+	s.Wallets = []string{s.TxFrom, s.To}
+	s.Amount0InS = s.Amount0In.String()
+	s.Amount1InS = s.Amount1In.String()
+	s.Amount0OutS = s.Amount0Out.String()
+	s.Amount1OutS = s.Amount1Out.String()
+	s.ValueUSDS = s.ValueUSD.String()
+}
+func (s *Swap) AfterLoad(ctx context.Context) {
+	s.Amount0In, _ = decimal.NewFromString(s.Amount0InS)
+	s.Amount1In, _ = decimal.NewFromString(s.Amount1InS)
+	s.Amount0Out, _ = decimal.NewFromString(s.Amount0OutS)
+	s.Amount1Out, _ = decimal.NewFromString(s.Amount1OutS)
+	s.ValueUSD, _ = decimal.NewFromString(s.ValueUSDS)
+}
+
+// types of LiquidityEvent
+const (
+	LiquidityMint = "mint"
+	LiquidityBurn = "burn"
+)
+
+// LiquidityEvent is a single Mint or Burn event on a pair, liquidity being added or removed
+type LiquidityEvent struct {
+	Type string `firestore:"type" json:"type"`
+
+	// Address is the pair's address
+	Address string `firestore:"address" json:"address"`
+	Pair    string `firestore:"pair" json:"pair"`
+	Token0  string `firestore:"token0" json:"token0"`
+	Token1  string `firestore:"token1" json:"token1"`
+
+	TxHash      string    `firestore:"txHash" json:"txHash"`
+	LogIndex    int64     `firestore:"logIndex" json:"logIndex"`
+	BlockNumber int64     `firestore:"blockNumber" json:"blockNumber"`
+	Time        time.Time `firestore:"time" json:"time"`
+
+	// From is who called the pair, typically the router. To got the LP tokens for a mint or the
+	// underlying tokens for a burn, and TxFrom sent the transaction.
+	From   string `firestore:"from" json:"from"`
+	To     string `firestore:"to" json:"to"`
+	TxFrom string `firestore:"txFrom" json:"txFrom"`
+
+	Amount0   decimal.Decimal `firestore:"-" json:"amount0"`
+	Amount1   decimal.Decimal `firestore:"-" json:"amount1"`
+	ValueUSD  decimal.Decimal `firestore:"-" json:"valueUSD"`  // at the hour's prices
+	Liquidity decimal.Decimal `firestore:"-" json:"liquidity"` // LP tokens minted or burned
+
+	// for firestore, see Swap
+	Wallets []string `firestore:"wallets" json:"-"`
+
+	Amount0S   string `firestore:"amount0" json:"-"`
+	Amount1S   string `firestore:"amount1" json:"-"`
+	ValueUSDS  string `firestore:"valueUSD" json:"-"`
+	LiquidityS string `firestore:"liquidity" json:"-"`
+}
+
+// PreSave Need these annoying things because firebase doesn't handle things properly
+func (e *LiquidityEvent) PreSave() {
+	e.Wallets = []string{e.TxFrom, e.To}
+	e.Amount0S = e.Amount0.String()
+	e.Amount1S = e.Amount1.String()
+	e.ValueUSDS = e.ValueUSD.String()
+	e.LiquidityS = e.Liquidity.String()
+}
+func (e *LiquidityEvent) AfterLoad(ctx context.Context) {
+	e.Amount0, _ = decimal.NewFromString(e.Amount0S)
+	e.Amount1, _ = decimal.NewFromString(e.Amount1S)
+	e.ValueUSD, _ = decimal.NewFromString(e.ValueUSDS)
+	e.Liquidity, _ = decimal.NewFromString(e.LiquidityS)
+}
+
+// LastCheck is where the collector got up to on the chain as a whole
+type LastCheck struct {
+	LastCheckAt     time.Time `firestore:"lastCheckAt" json:"lastCheckAt"`
+	LastBlockNumber int64     `firestore:"lastBlockNumber"  json:"lastBlockNumber"`
+	LastBlockHash   string    `firestore:"lastBlockHash"  json:"lastBlockHash"`
+
+	// recent checkpoints, oldest first, the last one matches the fields above
+	Checkpoints []*Checkpoint `firestore:"checkpoints" json:"checkpoints"`
+
+	// how far the hour in progress has been updated by collector watch, these blocks aren't final
+	PartialBlockNumber int64     `firestore:"partialBlockNumber" json:"partialBlockNumber"`
+	PartialAt          time.Time `firestore:"partialAt" json:"partialAt"`
+}
+
+// Checkpoint is the last block finalized by a run, with its hash so we can tell if it got reorged out
+type Checkpoint struct {
+	CheckAt     time.Time `firestore:"checkAt" json:"checkAt"`
+	BlockNumber int64     `firestore:"blockNumber" json:"blockNumber"`
+	BlockHash   string    `firestore:"blockHash" json:"blockHash"`
+}
+
+// PairCheck is the cursor for a single pair, so each pair can progress on its own
+type PairCheck struct {
+	Address         string    `firestore:"address" json:"address"`
+	LastCheckAt     time.Time `firestore:"lastCheckAt" json:"lastCheckAt"`
+	LastBlockNumber int64     `firestore:"lastBlockNumber"  json:"lastBlockNumber"`
+}
@@ -20,3 +20,25 @@ func IntToDec(i *big.Int, decimals uint8) decimal.Decimal {
 	d = d.Div(decimal.New(1, int32(decimals)))
 	return d
 }
+
+var (
+	// Q112 is 2^112, cumulative prices on pairs are UQ112x112 fixed point numbers
+	Q112       = new(big.Int).Lsh(big.NewInt(1), 112)
+	maxUint256 = new(big.Int).Lsh(big.NewInt(1), 256)
+)
+
+// TWAP returns the time weighted average price between two readings of a pair's cumulative price,
+// seconds apart, in whole quote tokens per whole base token. The contract lets cumulative prices
+// overflow, so an end smaller than start has wrapped around.
+func TWAP(start, end *big.Int, seconds int64, baseDecimals, quoteDecimals uint8) decimal.Decimal {
+	if seconds <= 0 {
+		return decimal.Zero
+	}
+	diff := new(big.Int).Sub(end, start)
+	if diff.Sign() < 0 {
+		diff.Add(diff, maxUint256)
+	}
+	num := decimal.NewFromBigInt(diff, int32(baseDecimals))
+	denom := decimal.NewFromBigInt(new(big.Int).Mul(Q112, big.NewInt(seconds)), int32(quoteDecimals))
+	return num.DivRound(denom, 18)
+}